@@ -16,27 +16,248 @@
 package main
 
 import (
+	"context"
 	_ "embed"
 	"flag"
+	"fmt"
 	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/jhjn/candl/server"
 )
 
+// A flag that may be given several times
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
 func main() {
 	verbose := flag.Bool("v", false, "print debug output")
 	dir := flag.String("wiki", ".", "directory containing markdown files")
 	port := flag.String("port", "8812", "port to listen on")
 	watch := flag.Bool("watch", false, "watch directory for changes")
+	debounce := flag.Duration("debounce", 200*time.Millisecond, "with -watch, wait until files stop changing for this long before reloading, 0 reloads on every change")
+	statusFile := flag.String("status-file", "", "write watcher status JSON to this file")
+	var watchGlobs, watchExcludes, ignore, auth, exts, noExts stringList
+	flag.Var(&watchGlobs, "watch-glob", "only reload for files matching this glob (repeatable)")
+	flag.Var(&watchExcludes, "watch-exclude", "never reload for files matching this glob (repeatable)")
+	flag.Var(&ignore, "ignore", "skip files and directories whose names match this glob, e.g. node_modules (repeatable)")
+	flag.Var(&exts, "extension", "also enable this markdown extension, e.g. mathjax (repeatable)")
+	flag.Var(&noExts, "no-extension", "disable this default markdown extension, e.g. mermaid (repeatable)")
+	noHints := flag.Bool("no-resource-hints", false, "don't send Link preload headers")
+	searchLinks := flag.Bool("search-links", true, "list every page as a backlink of /search")
+	unicodeNames := flag.Bool("unicode-names", false, "allow unicode letters in page names")
+	namePattern := flag.String("name-pattern", "", "regex page names must match, overrides -unicode-names")
+	slugs := flag.Bool("slugs", false, "treat spaces in page names as hyphens")
+	slugUnderscores := flag.Bool("slug-underscores", false, "with -slugs, also treat underscores as hyphens")
+	noBackup := flag.Bool("no-backup", false, "don't keep a .bak copy of edited pages")
+	noPreserveMtime := flag.Bool("no-preserve-mtime", false, "update mtimes of pages rewritten by a rename")
+	noLazyImages := flag.Bool("no-lazy-images", false, "don't lazy load images")
+	sanitize := flag.Bool("sanitize", true, "strip scripts, frames and event handlers from HTML in pages, -sanitize=false trusts it")
+	editLockTimeout := flag.Duration("edit-lock-timeout", 30*time.Minute, "how long an idle edit holds a page, 0 disables locking")
+	highlightStyle := flag.String("highlight-style", "monokai", "chroma style for code blocks, empty disables highlighting")
+	useGit := flag.Bool("git", false, "commit each saved page to the wiki's git repository")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "how long to let requests finish when stopping, 0 waits forever")
+	metrics := flag.Bool("metrics", false, "serve Prometheus metrics at /metrics")
+	noMermaid := flag.Bool("no-mermaid", false, "don't load Mermaid to draw diagrams, e.g. when offline")
+	mermaidCDN := flag.String("mermaid-cdn", server.DefaultMermaidCDN, "address of the Mermaid ES module")
+	flag.Var(&auth, "auth", "require HTTP Basic auth as user:password (repeatable)")
+	authPrivateOnly := flag.Bool("auth-private-only", false, "with -auth, only ask for credentials on pages with visibility: private")
+	authRealm := flag.String("auth-realm", "candl", "realm shown by the browser when asking for -auth credentials")
+	tlsCert := flag.String("tls-cert", "", "certificate file for serving HTTPS, needs -tls-key")
+	tlsKey := flag.String("tls-key", "", "private key file for -tls-cert")
+	tlsACME := flag.String("tls-acme", "", "serve HTTPS for this domain with a Let's Encrypt certificate, redirecting port 80")
+	basePath := flag.String("base-path", "", "path prefix when served behind a reverse proxy, e.g. /wiki")
+	baseURL := flag.String("base-url", "", "public address of the wiki, e.g. https://example.com/wiki, used by /sitemap.xml")
+	title := flag.String("title", "", "wiki title shown on pages and in the feed, defaults to the directory name")
+	embedDepth := flag.Int("embed-depth", 3, "how deeply {{embed:page}} directives may nest")
+	tocMinHeadings := flag.Int("toc-min-headings", 3, "show a table of contents on pages with at least this many headings")
+	backlinkSort := flag.String("backlink-sort", "default", "backlink order: default (dated pages last, newest first) or alpha")
+	includeDrafts := flag.Bool("include-drafts", false, "list draft pages everywhere, for local review")
+	export := flag.String("export", "", "write the wiki as static HTML to this directory then exit")
+	exportBase := flag.String("export-base-url", "", "prefix for absolute links in exported pages, e.g. /wiki")
+	checkLinks := flag.Bool("check-links", false, "check external links then exit")
+	checkConcurrency := flag.Int("check-links-concurrency", 8, "max parallel external link checks")
 	flag.Parse()
 
 	if *verbose {
 		slog.SetLogLoggerLevel(slog.LevelDebug)
 	}
 
-	err := server.Serve(*dir, *port, *watch)
+	for _, pattern := range ignore {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			slog.Error("invalid ignore pattern", "pattern", pattern, "error", err)
+			os.Exit(2)
+		}
+	}
+
+	var extensions []string
+	for _, name := range append(slices.Clone(exts), noExts...) {
+		if !server.IsMarkdownExtension(name) {
+			slog.Error("unknown markdown extension", "extension", name)
+			os.Exit(2)
+		}
+	}
+	for _, name := range append(slices.Clone(server.DefaultExtensions), exts...) {
+		if !slices.Contains(noExts, name) && !slices.Contains(extensions, name) {
+			extensions = append(extensions, name)
+		}
+	}
+
+	var sortOrder server.BacklinkSortOrder
+	switch *backlinkSort {
+	case "default":
+		sortOrder = server.SortDefault
+	case "alpha":
+		sortOrder = server.SortAlpha
+	default:
+		slog.Error("unknown backlink sort", "sort", *backlinkSort)
+		os.Exit(2)
+	}
+
+	wikiOpts := server.Options{
+		ImplicitSearchLinks:  *searchLinks,
+		LinkCheckConcurrency: *checkConcurrency,
+		NormalizeSlugs:       *slugs,
+		NormalizeUnderscores: *slugUnderscores,
+		NoBackup:             *noBackup,
+		NoPreserveMtime:      *noPreserveMtime,
+		NoLazyImages:         *noLazyImages,
+		NoSanitize:           !*sanitize,
+		EditLockTimeout:      *editLockTimeout,
+		BacklinkSort:         sortOrder,
+		HighlightStyle:       *highlightStyle,
+		TOCMinHeadings:       *tocMinHeadings,
+		Git:                  *useGit,
+		EmbedDepth:           *embedDepth,
+		Ignore:               ignore,
+		IncludeDrafts:        *includeDrafts,
+		Extensions:           extensions,
+	}
+
+	if *export != "" {
+		err := server.Export(*dir, *export, server.ExportOptions{
+			BaseURL: *exportBase,
+			Title:   *title,
+			Wiki:    wikiOpts,
+		})
+		if err != nil {
+			slog.Error("failed to export wiki", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *checkLinks {
+		if err := reportBrokenLinks(*dir, wikiOpts); err != nil {
+			slog.Error("failed to check links", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	validator, pattern := server.IsValidPageName, server.PageNamePattern
+	if *namePattern != "" {
+		v, err := server.PatternValidator(*namePattern)
+		if err != nil {
+			slog.Error("invalid name pattern", "pattern", *namePattern, "error", err)
+			os.Exit(2)
+		}
+		validator, pattern = v, *namePattern
+	} else if *unicodeNames {
+		validator, pattern = server.IsValidPageNameUnicode, server.PageNamePatternUnicode
+	}
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		slog.Error("-tls-cert and -tls-key must be given together")
+		os.Exit(2)
+	}
+	if *tlsACME != "" && *tlsCert != "" {
+		slog.Error("-tls-acme can't be used with -tls-cert")
+		os.Exit(2)
+	}
+	users := map[string]string{}
+	for _, a := range auth {
+		user, pass, ok := strings.Cut(a, ":")
+		if !ok || user == "" {
+			slog.Error("-auth must be user:password", "auth", a)
+			os.Exit(2)
+		}
+		users[user] = pass
+	}
+
+	portSet := false
+	flag.Visit(func(f *flag.Flag) { portSet = portSet || f.Name == "port" })
+	if *tlsACME != "" && !portSet {
+		*port = "443"
+	}
+
+	if *noMermaid {
+		*mermaidCDN = ""
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := server.Serve(ctx, *dir, server.ServeOptions{
+		Port:            *port,
+		Watch:           *watch,
+		Debounce:        *debounce,
+		NoResourceHints: *noHints,
+		NameValidator:   validator,
+		NamePattern:     pattern,
+		Title:           *title,
+		BaseURL:         *baseURL,
+		Metrics:         *metrics,
+		ShutdownTimeout: *shutdownTimeout,
+		MermaidCDN:      *mermaidCDN,
+		TLSCert:         *tlsCert,
+		TLSKey:          *tlsKey,
+		ACMEDomain:      *tlsACME,
+		Auth:            users,
+		AuthRealm:       *authRealm,
+		AuthPrivateOnly: *authPrivateOnly,
+		BasePath:        strings.TrimSuffix("/"+strings.Trim(*basePath, "/"), "/"),
+		Wiki:            wikiOpts,
+		WatchOptions: server.WatchOptions{
+			StatusFile:   *statusFile,
+			IncludeGlobs: watchGlobs,
+			ExcludeGlobs: watchExcludes,
+		},
+	})
 	if err != nil {
 		slog.Error("failed to load wiki", "error", err)
 	}
 
 }
+
+// Print every failing external link, returning an error if there were any.
+func reportBrokenLinks(dir string, opts server.Options) error {
+	wiki, err := server.NewWiki(dir, opts)
+	if err != nil {
+		return err
+	}
+	if err := wiki.ForceUpdate(); err != nil {
+		return err
+	}
+
+	results := wiki.CheckExternalLinks(context.Background(), 10*time.Second)
+	for _, r := range results {
+		fmt.Println(r)
+	}
+	if len(results) > 0 {
+		return fmt.Errorf("%d broken external links", len(results))
+	}
+	return nil
+}
@@ -1,19 +1,53 @@
 package server
 
 import (
+	"bytes"
+	"cmp"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
 	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"mime"
 	"net/http"
-	"regexp"
-	"text/template"
+	"path"
+	"slices"
+	"strings"
+	"time"
 )
 
 //go:embed edit.html
 var editTemplate string
 var editTmpl = template.Must(template.New("edit").Parse(editTemplate))
 
+//go:embed new.html
+var newTemplate string
+var newTmpl = template.Must(template.New("new").Parse(newTemplate))
+
 // A handler for mutating APIs
 type Api struct {
-	wiki *Wiki
+	wiki          *Wiki
+	NameValidator func(string) bool // defaults to IsValidPageName
+	NamePattern   string            // NameValidator's rule for the browser, defaults to PageNamePattern
+	secret        []byte            // signs delete confirmation tokens
+	basePath      string            // see ServeOptions.BasePath
+
+	authorized func(*http.Request) bool // whether a request may see private pages
+	authRealm  string                   // see ServeOptions.AuthRealm
+	pages      *Server                  // renders exported pages, nil uses default settings
+}
+
+func NewApi(wiki *Wiki, validator func(string) bool) *Api {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return &Api{wiki: wiki, NameValidator: validator, secret: secret}
 }
 
 // The handler for all wiki pages
@@ -23,53 +57,284 @@ func (a *Api) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		a.serveGetEdit(w, r)
 	} else if op == "edit" {
 		a.servePostEdit(w, r)
+	} else if r.Method == "POST" && op == "delete" {
+		a.servePostDelete(w, r)
+	} else if r.Method == "GET" && op == "history" {
+		a.serveHistory(w, r)
+	} else if r.Method == "GET" && op == "diff" {
+		a.serveDiff(w, r)
+	} else if r.Method == "GET" && op == "export" {
+		a.serveExport(w, r)
+	}
+}
+
+// Whether r may see private pages, never without a checker.
+func (a *Api) canSeePrivate(r *http.Request) bool {
+	return a.authorized != nil && a.authorized(r)
+}
+
+// Whether r may see page name, asking for credentials if not. Missing
+// pages are left for the handler to report.
+func (a *Api) mayRead(w http.ResponseWriter, r *http.Request, name string) bool {
+	if p, ok := a.wiki.ReadPage(name); ok && !a.wiki.visible(&p, a.canSeePrivate(r)) {
+		requestAuth(w, a.authRealm)
+		return false
 	}
+	return true
+}
+
+// Token proving a delete request came from our own edit page.
+// Another site can't read it so can't forge the request.
+func (a *Api) deleteToken(name string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte("delete:" + name))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Delete a page, requiring the token from the edit page.
+func (a *Api) servePostDelete(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := a.isValidName(name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !hmac.Equal([]byte(r.FormValue("confirm")), []byte(a.deleteToken(name))) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if !a.mayRead(w, r, name) {
+		return
+	}
+
+	if err := a.wiki.DeletePage(name); errors.Is(err, fs.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, a.basePath+"/index", http.StatusSeeOther)
 }
 
 // Serve the edit page for a specific page
 func (a *Api) serveGetEdit(w http.ResponseWriter, r *http.Request) {
 	name := r.PathValue("name")
-	a.wiki.mu.RLock()
-	page, ok := a.wiki.Pages[name]
-	a.wiki.mu.RUnlock()
+	if !a.mayRead(w, r, name) {
+		return
+	}
+	page, ok := a.wiki.ReadPage(name)
 
 	md := ""
 	if ok {
 		md = page.Raw
 	}
 
+	// Someone else editing means we can only look. Reopening our own
+	// editor, known by the CSRF cookie, gets the same lock back.
+	csrf := a.csrfCookie(w, r)
+	readOnly := r.FormValue("readonly") == "true"
+	lock := ""
+	if !readOnly {
+		var locked bool
+		lock, locked = a.wiki.AcquireEditLock(name, csrf)
+		readOnly = !locked
+	}
+
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	editTmpl.Execute(w, map[string]interface{}{
+	if err := editTmpl.Execute(w, map[string]interface{}{
 		"Name":     name,
 		"Markdown": md,
+		"ReadOnly": readOnly,
+		"Base":     a.basePath,
+		"Lock":     lock,
+		"Exists":   ok && page.Alias == "",
+		"Delete":   a.deleteToken(name),
+		"Draft":    ok && page.Draft,
+		"CSRF":     csrf,
+	}); err != nil {
+		slog.Error("edit template execute", "page", name, "error", err)
+	}
+}
+
+// Cookie holding the double-submit CSRF token for edits
+const csrfCookieName = "candl_csrf"
+
+// The CSRF token for this browser, set as a cookie if it hasn't one yet.
+// It's reused so that several open editors can all still save.
+func (a *Api) csrfCookie(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(csrfCookieName); err == nil && len(c.Value) == 64 {
+		return c.Value
+	}
+	b := make([]byte, 32)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		Path:     a.basePath + "/api/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
 	})
+	return token
+}
+
+// Whether the form's _csrf field matches the cookie. Another site can
+// make the browser send the cookie but can't read it to fill the field.
+func checkCSRF(r *http.Request) bool {
+	c, err := r.Cookie(csrfCookieName)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	return hmac.Equal([]byte(r.FormValue("_csrf")), []byte(c.Value))
+}
+
+// Download a page as a standalone HTML file. With ?linked=true links to
+// other pages point at {name}.html files beside it.
+func (a *Api) serveExport(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !a.mayRead(w, r, name) {
+		return
+	}
+	s := cmp.Or(a.pages, &Server{wiki: a.wiki})
+	export := pageExport{
+		linked:      r.FormValue("linked") == "true",
+		inlineStyle: true,
+		url:         s.baseURL(r) + escapeName(name),
+	}
+	var buf bytes.Buffer
+	if err := s.exportPageHTML(name, &buf, export); errors.Is(err, fs.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		slog.Error("page export", "page", name, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": path.Base(name) + ".html",
+	}))
+	w.Write(buf.Bytes())
+}
+
+// Render a form body or JSON content as the article of page name, for
+// previewing an edit. Nothing is saved.
+func (a *Api) servePreview(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name    string `json:"name"`
+		Content string `json:"content"`
+	}
+	if isJSON(r) {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		req.Name, req.Content = r.FormValue("name"), r.FormValue("body")
+	}
+
+	html, err := a.wiki.Preview(req.Name, req.Content, a.canSeePrivate(r))
+	if err != nil {
+		slog.Error("preview", "page", req.Name, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte("<article>" + string(html) + "</article>"))
+}
+
+// Why name can't be used for a page, or nil if it can. Names may be
+// slash separated paths like notes/foo, each part checked by the
+// validator. Whatever the validator, names must stay inside the wiki
+// directory and out of _static.
+func (a *Api) isValidName(name string) error {
+	if strings.ContainsAny(name, "\\\x00") {
+		return fmt.Errorf("invalid page name %q: names can't contain backslashes", name)
+	}
+	valid := a.NameValidator
+	if valid == nil {
+		valid = IsValidPageName
+	}
+	parts := strings.Split(name, "/")
+	if len(parts) > 1 && parts[0] == "_static" {
+		return fmt.Errorf("invalid page name %q: _static is for static files", name)
+	}
+	for _, part := range parts {
+		if part == "" || strings.HasPrefix(part, ".") {
+			return fmt.Errorf("invalid page name %q: names and the parts between slashes can't be empty or start with '.'", name)
+		}
+		if !valid(part) {
+			return fmt.Errorf("invalid page name %q: names must match %s", name, pathPattern(a.namePattern()))
+		}
+	}
+	return nil
+}
+
+func (a *Api) namePattern() string {
+	if a.NamePattern != "" {
+		return a.NamePattern
+	}
+	return PageNamePattern
 }
 
-func isValidName(name string) bool {
-	matched, err := regexp.MatchString("^[a-zA-Z0-9_+-]+$", name)
-	return err == nil && matched
+// Ask for a new page's name then send the user to its editor.
+func (a *Api) serveNew(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("name")
+	if name != "" {
+		if err := a.isValidName(name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, a.basePath+"/api/edit/"+escapeName(name)+"#content", http.StatusSeeOther)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	newTmpl.Execute(w, map[string]string{"Pattern": pathPattern(a.namePattern()), "Base": a.basePath})
 }
 
 // Update a page following an edit
 // Be careful - without proper validation this could be used to write arbitrary files
 func (a *Api) servePostEdit(w http.ResponseWriter, r *http.Request) {
 	oldName := r.PathValue("name")
-	body := r.FormValue("body")
+	body := strings.TrimPrefix(r.FormValue("body"), utf8BOM)
 	name := r.FormValue("name") // This will differ if the user renamed the file.
 
 	// Make sure the name was valid.
-	if !isValidName(oldName) {
-		w.WriteHeader(http.StatusBadRequest)
+	if err := a.isValidName(oldName); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	if !checkCSRF(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if !a.mayRead(w, r, oldName) || !a.mayRead(w, r, name) {
+		return
+	}
+
+	// Refuse to save over somebody else's edit.
+	lock := r.FormValue("lock")
+	if !a.wiki.CheckEditLock(oldName, lock) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	defer a.wiki.ReleaseEditLock(oldName, lock)
+
 	// If the user has renamed the page, change that first.
 	if name != oldName {
-		if !isValidName(name) {
-			w.WriteHeader(http.StatusBadRequest)
+		if err := a.isValidName(name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		if err := a.wiki.RenamePage(oldName, name); err != nil {
+		overwrite := r.FormValue("overwrite") == "true"
+		if err := a.wiki.RenamePage(oldName, name, overwrite); errors.Is(err, ErrPageExists) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		} else if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
@@ -85,5 +350,180 @@ func (a *Api) servePostEdit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	http.Redirect(w, r, "/"+name, http.StatusSeeOther)
+	http.Redirect(w, r, a.basePath+"/"+name, http.StatusSeeOther)
+}
+
+// A single page in a bulk update request
+type pageUpdate struct {
+	Name string `json:"name"`
+	Raw  string `json:"raw"`
+}
+
+// Summary returned by a bulk update
+type bulkUpdateResult struct {
+	Updated int      `json:"updated"`
+	Created int      `json:"created"`
+	Errors  []string `json:"errors"`
+}
+
+// Write many pages from a JSON array then reload the wiki once.
+// Nothing is written unless every name is valid and none of the pages is
+// open in someone's editor.
+func (a *Api) serveBulkUpdate(w http.ResponseWriter, r *http.Request) {
+	if !isJSON(r) {
+		writeJSON(w, http.StatusUnsupportedMediaType, bulkUpdateResult{Errors: []string{"Content-Type must be application/json"}})
+		return
+	}
+	var updates []pageUpdate
+	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+		writeJSON(w, http.StatusBadRequest, bulkUpdateResult{Errors: []string{err.Error()}})
+		return
+	}
+
+	res := bulkUpdateResult{Errors: []string{}}
+	contents := map[string]string{}
+	authorized := a.canSeePrivate(r)
+	status := http.StatusBadRequest
+	for _, u := range updates {
+		if err := a.isValidName(u.Name); err != nil {
+			res.Errors = append(res.Errors, err.Error())
+		} else if p, ok := a.wiki.ReadPage(u.Name); ok && !a.wiki.visible(&p, authorized) {
+			res.Errors = append(res.Errors, fmt.Sprintf("page %q is private", u.Name))
+		} else if !a.wiki.CheckEditLock(u.Name, "") {
+			// Their save would undo this one
+			res.Errors = append(res.Errors, fmt.Sprintf("page %q is being edited", u.Name))
+			status = http.StatusConflict
+		}
+		contents[u.Name] = u.Raw
+	}
+	if len(res.Errors) > 0 {
+		writeJSON(w, status, res)
+		return
+	}
+
+	created, err := a.wiki.WritePages(contents)
+	if err != nil {
+		res.Errors = append(res.Errors, err.Error())
+		writeJSON(w, http.StatusInternalServerError, res)
+		return
+	}
+	res.Created = created
+	res.Updated = len(contents) - created
+
+	// Every page is saved by now, so a failed reload (e.g. some other page
+	// is unreadable) is logged rather than reported as a failed update.
+	if err := a.wiki.UpdateWait(); err != nil {
+		slog.Error("reload after bulk update", "error", err)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// A page as listed by /api/pages
+type pageInfo struct {
+	Name           string    `json:"name"`
+	Title          string    `json:"title"`
+	LinksCount     int       `json:"links_count"`
+	BacklinksCount int       `json:"backlinks_count"`
+	Checksum       string    `json:"checksum"`
+	Modified       time.Time `json:"modified,omitzero"`   // zero for built-in pages
+	Links          []string  `json:"links,omitempty"`     // only with ?detail=full
+	Backlinks      []string  `json:"backlinks,omitempty"` // only with ?detail=full
+}
+
+// List every page sorted by name. ?detail=full includes the link arrays.
+func (a *Api) serveListPages(w http.ResponseWriter, r *http.Request) {
+	detail := r.FormValue("detail")
+	if detail != "" && detail != "summary" && detail != "full" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	authorized := a.canSeePrivate(r)
+	a.wiki.mu.RLock()
+	infos := make([]pageInfo, 0, len(a.wiki.Pages))
+	for _, p := range a.wiki.Pages {
+		if p.hidden || !a.wiki.visible(p, authorized) {
+			continue
+		}
+		backlinks := a.wiki.visibleNames(p.Backlinks, authorized)
+		info := pageInfo{
+			Name:           p.Name,
+			Title:          p.Title,
+			LinksCount:     len(p.Links),
+			BacklinksCount: len(backlinks),
+			Checksum:       p.Checksum,
+			Modified:       p.ModTime,
+		}
+		if detail == "full" {
+			info.Links = slices.Sorted(maps.Keys(p.Links))
+			info.Backlinks = backlinks
+		}
+		infos = append(infos, info)
+	}
+	a.wiki.mu.RUnlock()
+
+	slices.SortFunc(infos, func(a, b pageInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	writeJSON(w, http.StatusOK, infos)
+}
+
+// Full-text search results for ?q= as JSON.
+func (a *Api) serveSearch(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.wiki.Search(r.FormValue("q"), a.canSeePrivate(r)))
+}
+
+// Pages to complete a wikilink starting ?q=, as JSON.
+func (a *Api) serveAutocomplete(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.wiki.Autocomplete(r.FormValue("q")))
+}
+
+// Broken wikilinks keyed by the page containing them, as JSON.
+func (a *Api) serveBrokenLinks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.wiki.BrokenLinks(a.canSeePrivate(r)))
+}
+
+// Pages with no links in or out, as JSON.
+func (a *Api) serveOrphans(w http.ResponseWriter, r *http.Request) {
+	infos := []pageInfo{}
+	for _, p := range a.wiki.OrphanedPages(a.canSeePrivate(r)) {
+		infos = append(infos, pageInfo{Name: p.Name, Title: p.Title, Checksum: p.Checksum, Modified: p.ModTime})
+	}
+	writeJSON(w, http.StatusOK, infos)
+}
+
+// At most this many cycles are returned by /api/graph/cycles
+const maxCycles = 50
+
+// List the largest link cycles in the wiki as JSON arrays of page names.
+func (a *Api) serveCycles(w http.ResponseWriter, r *http.Request) {
+	cycles := a.wiki.Cycles(a.canSeePrivate(r))
+	if len(cycles) > maxCycles {
+		cycles = cycles[:maxCycles]
+	}
+	writeJSON(w, http.StatusOK, cycles)
+}
+
+// Report the wiki's activity counters as JSON.
+func (a *Api) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	m := &a.wiki.Metrics
+	writeJSON(w, http.StatusOK, map[string]int64{
+		"watcher_event_count": m.WatcherEventCount.Load(),
+		"watcher_error_count": m.WatcherErrorCount.Load(),
+		"reload_count":        m.ReloadCount.Load(),
+	})
+}
+
+// Whether r's body is declared to be JSON.
+func isJSON(r *http.Request) bool {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mt == "application/json"
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("json encode", "error", err)
+	}
 }
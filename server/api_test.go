@@ -0,0 +1,198 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A loaded wiki in a temporary directory holding pages, a map of page
+// name to markdown.
+func newTestWiki(t testing.TB, pages map[string]string) *Wiki {
+	t.Helper()
+	dir := t.TempDir()
+	for name, raw := range pages {
+		path := filepath.Join(dir, filepath.FromSlash(name)+".md")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(raw), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wiki, err := NewWiki(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wiki.ForceUpdate(); err != nil {
+		t.Fatal(err)
+	}
+	return wiki
+}
+
+// The edit API routed as Serve routes it.
+func newTestApi(wiki *Wiki) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/api/{op}/{name...}", NewApi(wiki, nil))
+	return mux
+}
+
+func postForm(h http.Handler, path string, form url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestEditRequiresCSRFToken(t *testing.T) {
+	wiki := newTestWiki(t, map[string]string{"foo": "# Foo\n"})
+	api := newTestApi(wiki)
+	cookie := &http.Cookie{Name: csrfCookieName, Value: strings.Repeat("a", 64)}
+
+	tests := []struct {
+		name    string
+		form    url.Values
+		cookies []*http.Cookie
+		want    int
+	}{
+		{"no token", url.Values{"name": {"foo"}, "body": {"pwned"}}, nil, http.StatusForbidden},
+		{"no cookie", url.Values{"name": {"foo"}, "body": {"pwned"}, "_csrf": {cookie.Value}}, nil, http.StatusForbidden},
+		{"wrong token", url.Values{"name": {"foo"}, "body": {"pwned"}, "_csrf": {strings.Repeat("b", 64)}}, []*http.Cookie{cookie}, http.StatusForbidden},
+		{"token", url.Values{"name": {"foo"}, "body": {"# Saved\n"}, "_csrf": {cookie.Value}}, []*http.Cookie{cookie}, http.StatusSeeOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := postForm(api, "/api/edit/foo", tt.form, tt.cookies...)
+			if w.Code != tt.want {
+				t.Fatalf("POST /api/edit/foo: got %d, want %d", w.Code, tt.want)
+			}
+			b, err := os.ReadFile(filepath.Join(wiki.Dir, "foo.md"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(b), "pwned") {
+				t.Errorf("forged edit was saved: %q", b)
+			}
+		})
+	}
+}
+
+func TestEditPageEscapesName(t *testing.T) {
+	api := newTestApi(newTestWiki(t, nil))
+
+	r := httptest.NewRequest("GET", "/api/edit/"+url.PathEscape(`"><script>alert(1)</script>`), nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want %d", w.Code, http.StatusOK)
+	}
+	if body := w.Body.String(); strings.Contains(body, "<script>alert(1)") {
+		t.Errorf("page name isn't escaped:\n%s", body)
+	}
+}
+
+func TestEditPageMarksDraftWhileLocked(t *testing.T) {
+	wiki := newTestWiki(t, map[string]string{"foo": "---\ndraft: true\n---\n# Foo\n"})
+	wiki.Options.EditLockTimeout = time.Minute
+	api := newTestApi(wiki)
+
+	// The second editor can't take the lock but is still editing a draft
+	for _, editor := range []string{"first", "second"} {
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, httptest.NewRequest("GET", "/api/edit/foo", nil))
+		if !strings.Contains(w.Body.String(), `id="draft"`) {
+			t.Errorf("%s editor: draft not marked", editor)
+		}
+	}
+}
+
+func TestEditStripsBOM(t *testing.T) {
+	wiki := newTestWiki(t, nil)
+	cookie := &http.Cookie{Name: csrfCookieName, Value: strings.Repeat("a", 64)}
+
+	form := url.Values{"name": {"pasted"}, "body": {utf8BOM + "# Pasted\n"}, "_csrf": {cookie.Value}}
+	if w := postForm(newTestApi(wiki), "/api/edit/pasted", form, cookie); w.Code != http.StatusSeeOther {
+		t.Fatalf("got %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	b, err := os.ReadFile(filepath.Join(wiki.Dir, "pasted.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "# Pasted\n" {
+		t.Errorf("saved %q, want %q", b, "# Pasted\n")
+	}
+}
+
+func postBulk(wiki *Wiki, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/api/pages/bulk-update", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	NewApi(wiki, nil).serveBulkUpdate(w, r)
+	return w
+}
+
+func TestBulkUpdateWaitsForReload(t *testing.T) {
+	wiki := newTestWiki(t, nil)
+
+	// As if the watcher were part way through a reload
+	wiki.reloadSem <- struct{}{}
+	time.AfterFunc(50*time.Millisecond, func() { <-wiki.reloadSem })
+
+	w := postBulk(wiki, `[{"name": "new", "raw": "# New\n"}]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if _, ok := wiki.ReadPage("new"); !ok {
+		t.Error("written page wasn't loaded")
+	}
+}
+
+func TestBulkUpdateRespectsEditLocks(t *testing.T) {
+	wiki := newTestWiki(t, map[string]string{"open": "# Open\n"})
+	wiki.Options.EditLockTimeout = time.Minute
+	if _, ok := wiki.AcquireEditLock("open", "editor"); !ok {
+		t.Fatal("lock refused")
+	}
+
+	w := postBulk(wiki, `[{"name": "open", "raw": "# Replaced\n"}, {"name": "other", "raw": "# Other\n"}]`)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("got %d, want %d: %s", w.Code, http.StatusConflict, w.Body)
+	}
+	files := readTree(t, wiki.Dir)
+	if files["open.md"] != "# Open\n" {
+		t.Errorf("open.md was overwritten with %q", files["open.md"])
+	}
+	if _, ok := files["other.md"]; ok {
+		t.Error("other.md was written despite the conflict")
+	}
+}
+
+func TestListPagesCountsVisibleBacklinks(t *testing.T) {
+	wiki := newTestWiki(t, map[string]string{
+		"public": "# Public\n",
+		"secret": "---\nvisibility: private\n---\n# Secret\n\n[[public]]\n",
+	})
+	w := httptest.NewRecorder()
+	NewApi(wiki, nil).serveListPages(w, httptest.NewRequest("GET", "/api/pages?detail=summary", nil))
+
+	var infos []pageInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &infos); err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range infos {
+		if info.Name == "public" && info.BacklinksCount != 0 {
+			t.Errorf("public has %d backlinks counted, want none from the private page", info.BacklinksCount)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+)
+
+// Whether a request has HTTP Basic credentials matching one of users, a
+// map of user name to password.
+func basicAuthChecker(users map[string]string) func(r *http.Request) bool {
+	// Hashing makes every comparison the same length, so timing reveals
+	// neither the password nor whether the user exists.
+	hashes := map[string][32]byte{}
+	for user, pass := range users {
+		hashes[user] = sha256.Sum256([]byte(pass))
+	}
+	return func(r *http.Request) bool {
+		user, pass, ok := r.BasicAuth()
+		want, known := hashes[user]
+		got := sha256.Sum256([]byte(pass))
+		return subtle.ConstantTimeCompare(got[:], want[:]) == 1 && ok && known
+	}
+}
+
+// Ask the browser for Basic credentials.
+func requestAuth(w http.ResponseWriter, realm string) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", realm))
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+// Require HTTP Basic credentials matching one of users, a map of user
+// name to password, for everything but the stylesheet and /healthz. The
+// browser's login dialog then doesn't show an unstyled page behind it,
+// and health checks don't need credentials.
+func basicAuth(next http.Handler, users map[string]string, realm string) http.Handler {
+	authorized := basicAuthChecker(users)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/style.css" || r.URL.Path == "/healthz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !authorized(r) {
+			requestAuth(w, realm)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// A claim on editing a page, held by whoever has the token.
+type EditLock struct {
+	Token   string
+	Holder  string // who took it, e.g. a browser's CSRF token
+	Expires time.Time
+}
+
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Take the edit lock for a page for holder, returning its token.
+// Fails if someone else holds an unexpired lock; if holder already has
+// it, e.g. after reopening the editor, its token is returned again.
+// Locking is disabled (any token is accepted) when
+// Options.EditLockTimeout is zero.
+func (w *Wiki) AcquireEditLock(name, holder string) (string, bool) {
+	if w.Options.EditLockTimeout == 0 {
+		return "", true
+	}
+	w.editMu.Lock()
+	defer w.editMu.Unlock()
+
+	now := time.Now()
+	// Abandoned editors never release their locks
+	for page, l := range w.EditLocks {
+		if now.After(l.Expires) {
+			delete(w.EditLocks, page)
+		}
+	}
+
+	l, ok := w.EditLocks[name]
+	if ok && (holder == "" || l.Holder != holder) {
+		return "", false
+	}
+	if !ok {
+		l = EditLock{Token: newToken(), Holder: holder}
+	}
+	l.Expires = now.Add(w.Options.EditLockTimeout)
+	w.EditLocks[name] = l
+	return l.Token, true
+}
+
+// Whether a save with token may go ahead: either it holds the lock or
+// nobody else holds an unexpired one. A valid lock is extended.
+func (w *Wiki) CheckEditLock(name string, token string) bool {
+	if w.Options.EditLockTimeout == 0 {
+		return true
+	}
+	w.editMu.Lock()
+	defer w.editMu.Unlock()
+
+	l, ok := w.EditLocks[name]
+	if ok && l.Token == token {
+		l.Expires = time.Now().Add(w.Options.EditLockTimeout)
+		w.EditLocks[name] = l
+		return true
+	}
+	return !ok || time.Now().After(l.Expires)
+}
+
+// Give up the lock on a page if token holds it.
+func (w *Wiki) ReleaseEditLock(name string, token string) {
+	w.editMu.Lock()
+	defer w.editMu.Unlock()
+
+	if l, ok := w.EditLocks[name]; ok && l.Token == token {
+		delete(w.EditLocks, name)
+	}
+}
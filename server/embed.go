@@ -0,0 +1,55 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// A {{embed:name}} directive on its own line, as rendered by goldmark.
+var embedRe = regexp.MustCompile(`<p>\{\{embed:([^}\s]+)\}\}</p>`)
+
+// Replace embed directives in a page's HTML with the HTML of the pages
+// they name, recursively up to Options.EmbedDepth levels. Cycles and
+// missing pages become HTML comments, as do private pages unless private
+// is set, so a public page can't show a private one.
+func (w *Wiki) expandEmbeds(pages map[string]*Page, p *Page, private bool) template.HTML {
+	depth := w.Options.EmbedDepth
+	if depth <= 0 {
+		depth = 3
+	}
+
+	var expand func(src template.HTML, path []string) template.HTML
+	expand = func(src template.HTML, path []string) template.HTML {
+		return template.HTML(embedRe.ReplaceAllStringFunc(string(src), func(m string) string {
+			name := html.UnescapeString(embedRe.FindStringSubmatch(m)[1])
+			next := append(slices.Clip(path), name)
+			chain := strings.Join(next, " -> ")
+			target, ok := pages[name]
+			switch {
+			case !ok:
+				return embedComment("no page " + name)
+			case !w.visible(target, private):
+				return embedComment("private page " + name)
+			case len(path) > depth:
+				return embedComment("too deep: " + chain)
+			}
+			for _, seen := range path {
+				if seen == name {
+					return embedComment("cycle: " + chain)
+				}
+			}
+			return fmt.Sprintf(`<div class="embed" data-page="%s">%s</div>`,
+				html.EscapeString(name), expand(target.rendered, next))
+		}))
+	}
+	return expand(p.rendered, []string{p.Name})
+}
+
+func embedComment(msg string) string {
+	// "--" can't appear inside a comment
+	return "<!-- candl: embed: " + strings.ReplaceAll(msg, "--", "- -") + " -->"
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Settings for exporting a wiki as static HTML.
+type ExportOptions struct {
+	BaseURL string // prefixed to absolute paths, for serving from a subdirectory
+	Title   string // wiki name for pages, defaults to the directory name
+	Wiki    Options
+}
+
+// Write every page of the wiki in dir to outDir as {name}.html, along with
+// style.css and _static, so it can be browsed without a server. Nothing
+// guards the files so private pages aren't exported.
+func Export(dir, outDir string, opts ExportOptions) error {
+	wiki, err := NewWiki(dir, opts.Wiki)
+	if err != nil {
+		return err
+	}
+	if err := wiki.ForceUpdate(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return err
+	}
+
+	if opts.Title == "" {
+		if opts.Title, err = dirTitle(dir); err != nil {
+			return err
+		}
+	}
+	s := &Server{wiki: wiki, opts: ServeOptions{Title: opts.Title, BaseURL: opts.BaseURL}}
+	base := strings.TrimSuffix(opts.BaseURL, "/")
+	for name := range wiki.Pages {
+		page, _ := wiki.ReadPage(name)
+		if page.hidden || !page.IsPublic() {
+			continue
+		}
+		export := pageExport{base: base, linked: true}
+		if base != "" {
+			export.url = base + "/" + escapeName(name) + ".html"
+		}
+		b, err := s.exportPage(page, export)
+		if err != nil {
+			return fmt.Errorf("exporting %s: %w", name, err)
+		}
+		file := filepath.Join(outDir, filepath.FromSlash(name)+".html")
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(file, b, 0644); err != nil {
+			return err
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(outDir, "style.css"), []byte(wiki.Style), 0644); err != nil {
+		return err
+	}
+	return copyStatic(filepath.Join(dir, "_static"), filepath.Join(outDir, "_static"))
+}
+
+// Write a page as a standalone HTML document, with the stylesheet in a
+// <style> tag rather than linked. Links are left as the server has them.
+func (w *Wiki) ExportPageHTML(name string, out io.Writer) error {
+	return (&Server{wiki: w}).exportPageHTML(name, out, pageExport{inlineStyle: true})
+}
+
+// Like ExportPageHTML but rendered with the server's settings, such as
+// the wiki title.
+func (s *Server) exportPageHTML(name string, out io.Writer, opts pageExport) error {
+	page, ok := s.wiki.ReadPage(name)
+	if !ok {
+		return fmt.Errorf("exporting %s: %w", name, fs.ErrNotExist)
+	}
+	b, err := s.exportPage(page, opts)
+	if err != nil {
+		return fmt.Errorf("exporting %s: %w", name, err)
+	}
+	_, err = out.Write(b)
+	return err
+}
+
+// How exportPage renders a page.
+type pageExport struct {
+	base        string // prefixed to absolute paths, see ExportOptions.BaseURL
+	linked      bool   // point links at other exported files
+	inlineStyle bool   // put style.css in a <style> tag
+	url         string // the page's public address for og:url, if known
+}
+
+// Render a page with the wiki template for viewing without a server.
+func (s *Server) exportPage(page Page, opts pageExport) ([]byte, error) {
+	if target := cmp.Or(page.Alias, page.RedirectTo); target != "" {
+		target := template.HTMLEscapeString(rootPrefix(page.Name) + target + ".html")
+		return []byte(`<!DOCTYPE html><meta http-equiv="refresh" content="0; url=` + target + `">`), nil
+	}
+
+	content := page.HTML
+	if generate, ok := generatedPages[page.Name]; ok {
+		r, err := http.NewRequest("GET", "/"+page.Name, nil)
+		if err != nil {
+			return nil, err
+		}
+		extra, err := generate(s, r)
+		if err != nil {
+			return nil, err
+		}
+		content += extra
+	}
+
+	s.wiki.mu.RLock()
+	tmpl, style := s.wiki.Template, s.wiki.Style
+	s.wiki.mu.RUnlock()
+
+	var buf bytes.Buffer
+	data := s.pageData(page, content)
+	data["URL"] = opts.url
+	data["LiveReload"] = false // nothing to reload from
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return transformDocument(buf.Bytes(), func(n *html.Node) {
+		if opts.inlineStyle && n.DataAtom == atom.Link && attrValue(n, "rel") == "stylesheet" &&
+			path.Base(attrValue(n, "href")) == "style.css" {
+			n.DataAtom, n.Data, n.Attr = atom.Style, "style", nil
+			n.AppendChild(&html.Node{Type: html.TextNode, Data: style})
+			return
+		}
+		if !opts.linked {
+			return
+		}
+		// Broken wikilinks have no file to point at so just stay marked
+		if n.DataAtom == atom.A && slices.Contains(strings.Fields(attrValue(n, "class")), "broken") {
+			n.Attr = slices.DeleteFunc(n.Attr, func(a html.Attribute) bool {
+				return a.Key == "href"
+			})
+			return
+		}
+		for i, a := range n.Attr {
+			if a.Key == "href" || a.Key == "src" {
+				n.Attr[i].Val = s.exportURL(a.Val, opts.base, page.Name)
+			}
+		}
+	})
+}
+
+// Where a link from the exported page from should point. Links to pages
+// gain .html and absolute paths are made relative, or prefixed with base
+// if given.
+func (s *Server) exportURL(href, base, from string) string {
+	u, err := url.Parse(href)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return href
+	}
+
+	if !strings.HasPrefix(u.Path, "/") {
+		// Already relative to from, see rebaseLinks
+		if s.wiki.hasPage(path.Join(path.Dir(from), u.Path)) {
+			u.Path += ".html"
+		}
+		return u.String()
+	}
+
+	name := strings.TrimPrefix(u.Path, "/")
+	if name == "" {
+		name = "index" // like the / redirect
+	}
+	if s.wiki.hasPage(name) {
+		u.Path = name + ".html"
+	} else {
+		u.Path = name
+	}
+
+	if base != "" {
+		return base + "/" + u.String()
+	}
+	return rootPrefix(from) + u.String()
+}
+
+// Copy the _static directory, if there is one, skipping the same files
+// that staticHandler refuses to serve.
+func copyStatic(src, dst string) error {
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		base := d.Name()
+		if rel != "." && (strings.HasPrefix(base, ".") || strings.HasSuffix(base, ".md") || strings.HasSuffix(base, ".bak")) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dst, rel), b, 0644)
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
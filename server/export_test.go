@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportUsesTitleAndURL(t *testing.T) {
+	wiki := newTestWiki(t, map[string]string{"notes/foo": "# Foo\n"})
+	out := t.TempDir()
+	opts := ExportOptions{BaseURL: "https://example.com/wiki/", Title: "Notebook"}
+	if err := Export(wiki.Dir, out, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(out, "notes", "foo.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"<title>Notebook – Foo</title>", `content="https://example.com/wiki/notes/foo.html"`} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("exported page is missing %s:\n%s", want, b)
+		}
+	}
+}
+
+func TestLiveReloadOnlyWhenWatching(t *testing.T) {
+	wiki := newTestWiki(t, map[string]string{"foo": "# Foo\n"})
+	page, _ := wiki.ReadPage("foo")
+
+	for _, watch := range []bool{false, true} {
+		s := &Server{wiki: wiki, opts: ServeOptions{Watch: watch}}
+		mux := http.NewServeMux()
+		mux.Handle("/{name...}", s)
+		if got := strings.Contains(get(mux, "/foo").Body.String(), "/ws/reload"); got != watch {
+			t.Errorf("watching %v: served page has reload script %v", watch, got)
+		}
+
+		b, err := s.exportPage(page, pageExport{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(b), "/ws/reload") {
+			t.Errorf("watching %v: exported page has the reload script", watch)
+		}
+	}
+}
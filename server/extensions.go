@@ -0,0 +1,61 @@
+package server
+
+import (
+	"fmt"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	attributes "github.com/mdigger/goldmark-attributes"
+	fences "github.com/stefanfritsch/goldmark-fences"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+// Markdown extensions that can be turned on and off by name.
+var markdownExtensions = map[string]goldmark.Extender{
+	"gfm":    extension.GFM,
+	"fences": &fences.Extender{}, // ::: div fences
+	"attrs":  attributes.Extension,
+	// Colours come from classes, see highlightCSS
+	"highlighting": highlighting.NewHighlighting(
+		highlighting.WithFormatOptions(chromahtml.WithClasses(true)),
+	),
+	"mermaid": mermaidExtender{},
+	"mathjax": mathJaxExtender{},
+}
+
+// Extensions used when Options.Extensions is nil.
+var DefaultExtensions = []string{"gfm", "fences", "attrs", "highlighting", "mermaid"}
+
+// Whether name is one of the markdown extensions, e.g. "gfm".
+func IsMarkdownExtension(name string) bool {
+	_, ok := markdownExtensions[name]
+	return ok
+}
+
+// Markdown parser with the extensions named in opts, by default
+// gfm + ::: fences + {.foo} attrs + highlighted code + mermaid.
+func newMarkdown(opts Options) (goldmark.Markdown, error) {
+	names := opts.Extensions
+	if names == nil {
+		names = DefaultExtensions
+	}
+	var exts []goldmark.Extender
+	for _, name := range names {
+		ext, ok := markdownExtensions[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown markdown extension %q", name)
+		}
+		if name == "highlighting" && opts.HighlightStyle == "" {
+			continue
+		}
+		exts = append(exts, ext)
+	}
+	return goldmark.New(
+		goldmark.WithExtensions(exts...),
+		goldmark.WithParserOptions(parser.WithAttribute(), parser.WithAutoHeadingID()),
+		goldmark.WithRendererOptions(html.WithUnsafe()),
+	), nil
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/xml"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// At most this many pages are listed in /feed.xml
+const feedLength = 20
+
+// An Atom 1.0 feed, see RFC 4287.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Content atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// Serve the most recently modified pages as an Atom feed.
+func (s *Server) serveFeed(w http.ResponseWriter, r *http.Request) {
+	base := s.baseURL(r)
+
+	pages := s.wiki.RecentChanges(feedLength, s.canSeePrivate(r))
+	feed := atomFeed{
+		ID:      base,
+		Title:   s.opts.Title,
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Author:  atomAuthor{Name: s.opts.Title},
+		Links: []atomLink{
+			{Href: base},
+			{Href: base + "feed.xml", Rel: "self"},
+		},
+	}
+	if len(pages) > 0 {
+		feed.Updated = pages[0].ModTime.UTC().Format(time.RFC3339)
+	}
+	for _, p := range pages {
+		title := p.Title
+		if title == "" {
+			title = p.Name
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:      base + p.Name,
+			Title:   title,
+			Updated: p.ModTime.UTC().Format(time.RFC3339),
+			Link:    atomLink{Href: base + p.Name},
+			Content: atomContent{Type: "html", Body: string(p.HTML)},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		slog.Error("feed encode", "error", err)
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Split a leading ---yaml--- or +++toml+++ block from the markdown.
+// If there is no block, meta is nil and body is raw. If the block can't be
+// parsed it is still removed from body and the error returned.
+func splitFrontmatter(raw string) (meta map[string]interface{}, body string, err error) {
+	var delim string
+	switch {
+	case strings.HasPrefix(raw, "---\n"):
+		delim = "---"
+	case strings.HasPrefix(raw, "+++\n"):
+		delim = "+++"
+	default:
+		return nil, raw, nil
+	}
+
+	rest := raw[len(delim)+1:]
+	end := strings.Index(rest, "\n"+delim+"\n")
+	block := ""
+	if strings.HasPrefix(rest, delim+"\n") { // empty block
+		body = rest[len(delim)+1:]
+	} else if end >= 0 {
+		block, body = rest[:end], rest[end+len(delim)+2:]
+	} else if strings.HasSuffix(rest, "\n"+delim) { // nothing after the block
+		block = strings.TrimSuffix(rest, "\n"+delim)
+	} else {
+		return nil, raw, nil // not frontmatter, just a leading thematic break
+	}
+
+	meta = map[string]interface{}{}
+	if delim == "---" {
+		err = yaml.Unmarshal([]byte(block), &meta)
+	} else {
+		err = toml.Unmarshal([]byte(block), &meta)
+	}
+	if err != nil {
+		return nil, body, err
+	}
+	return meta, body, nil
+}
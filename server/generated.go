@@ -0,0 +1,124 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Pages that exist even if the wiki has no markdown for them, by title.
+var builtinPages = map[string]string{
+	"search":         "Search",
+	"broken-links":   "Broken links",
+	"recent-changes": "Recent changes",
+	"orphans":        "Orphaned pages",
+	"tags":           "Tags",
+	"stats":          "Statistics",
+	"graph":          "Link graph",
+}
+
+// Pages whose content is generated on each request. The result is
+// appended to any markdown the wiki has for them.
+var generatedPages = map[string]func(s *Server, r *http.Request) (template.HTML, error){
+	"search":         (*Server).searchContent,
+	"broken-links":   (*Server).brokenLinksContent,
+	"recent-changes": (*Server).recentChangesContent,
+	"orphans":        (*Server).orphansContent,
+	"tags":           (*Server).tagsContent,
+	"stats":          (*Server).statsContent,
+	"graph":          (*Server).graphContent,
+}
+
+// Execute a template into HTML for embedding in a page.
+func renderFragment(tmpl *template.Template, data any) (template.HTML, error) {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return template.HTML(sb.String()), nil
+}
+
+// Search box and results for ?q=
+func (s *Server) searchContent(r *http.Request) (template.HTML, error) {
+	q := r.FormValue("q")
+	return renderFragment(searchTmpl, map[string]any{
+		"Query":   q,
+		"Results": s.wiki.Search(q, s.canSeePrivate(r)),
+	})
+}
+
+var brokenLinksTmpl = template.Must(template.New("broken-links").Parse(`
+<ul class="broken-links">
+  {{range $page, $targets := .}}
+  <li><a href="{{$page}}">{{$page}}</a> →
+    {{range $targets}}<a class="broken" href="{{.}}">{{.}}</a> {{end}}
+  </li>
+  {{else}}
+  <li>no broken links</li>
+  {{end}}
+</ul>`))
+
+// Every page with wikilinks to pages that don't exist
+func (s *Server) brokenLinksContent(r *http.Request) (template.HTML, error) {
+	return renderFragment(brokenLinksTmpl, s.wiki.BrokenLinks(s.canSeePrivate(r)))
+}
+
+var recentChangesTmpl = template.Must(template.New("recent-changes").Funcs(template.FuncMap{"timeAgo": timeAgo}).Parse(`
+<ul class="recent-changes">
+  {{range .}}
+  <li><a href="{{.Name}}">{{or .Title .Name}}</a> <time datetime="{{.ModTime.Format "2006-01-02T15:04:05Z07:00"}}">{{timeAgo .ModTime}}</time></li>
+  {{else}}
+  <li>no pages</li>
+  {{end}}
+</ul>`))
+
+// The most recently modified pages, 50 unless ?limit= says otherwise
+func (s *Server) recentChangesContent(r *http.Request) (template.HTML, error) {
+	limit, err := strconv.Atoi(r.FormValue("limit"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	return renderFragment(recentChangesTmpl, s.wiki.RecentChanges(limit, s.canSeePrivate(r)))
+}
+
+// A rough description of how long ago t was, like "3 minutes ago".
+func timeAgo(t time.Time) string {
+	d := time.Since(t)
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s ago", unit)
+		}
+		return fmt.Sprintf("%d %ss ago", n, unit)
+	}
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "hour")
+	case d < 30*24*time.Hour:
+		return plural(int(d/(24*time.Hour)), "day")
+	case d < 365*24*time.Hour:
+		return plural(int(d/(30*24*time.Hour)), "month")
+	default:
+		return plural(int(d/(365*24*time.Hour)), "year")
+	}
+}
+
+var orphansTmpl = template.Must(template.New("orphans").Parse(`
+<ul class="orphans">
+  {{range .}}
+  <li><a href="{{.Name}}">{{or .Title .Name}}</a></li>
+  {{else}}
+  <li>no orphaned pages</li>
+  {{end}}
+</ul>`))
+
+// Every page with no links in or out
+func (s *Server) orphansContent(r *http.Request) (template.HTML, error) {
+	return renderFragment(orphansTmpl, s.wiki.OrphanedPages(s.canSeePrivate(r)))
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Returned by git operations when the wiki directory isn't in a repository.
+var ErrNotGitRepo = errors.New("wiki directory is not a git repository")
+
+// A commit that changed a page.
+type GitCommit struct {
+	Hash    string    `json:"hash"`
+	Author  string    `json:"author"`
+	Date    time.Time `json:"date"`
+	Message string    `json:"message"`
+}
+
+// Revisions accepted by PageDiff, so they can't be mistaken for options.
+var revisionRe = regexp.MustCompile("^[0-9a-fA-F]{4,40}$")
+
+// Run git in the wiki directory, returning its output.
+func (w *Wiki) git(args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Dir = w.Dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+func (w *Wiki) isGitRepo() bool {
+	out, err := w.git("rev-parse", "--is-inside-work-tree")
+	return err == nil && strings.TrimSpace(out) == "true"
+}
+
+// Commit the current state of a page's file, if it changed.
+func (w *Wiki) commitPage(name, message string) error {
+	if !w.isGitRepo() {
+		return ErrNotGitRepo
+	}
+	return w.commitFiles(message, getPageFile(name))
+}
+
+// Commit the current state of files, relative to the wiki directory,
+// including their removal. Other changes in the repo are left alone.
+func (w *Wiki) commitFiles(message string, files ...string) error {
+	args := append([]string{"add", "-A", "--"}, files...)
+	if _, err := w.git(args...); err != nil {
+		return err
+	}
+	// Exits 0 when nothing is staged, e.g. saving without changes
+	args = append([]string{"diff", "--cached", "--quiet", "--"}, files...)
+	if _, err := w.git(args...); err == nil {
+		return nil
+	}
+	args = append([]string{"commit", "-m", message, "--"}, files...)
+	_, err := w.git(args...)
+	return err
+}
+
+// Commits that changed a page, newest first.
+func (w *Wiki) PageHistory(name string) ([]GitCommit, error) {
+	if !w.isGitRepo() {
+		return nil, ErrNotGitRepo
+	}
+	// Unit and record separators can't appear in the fields
+	out, err := w.git("log", "--follow", "--format=%H%x1f%an%x1f%aI%x1f%s%x1e", "--", getPageFile(name))
+	if err != nil {
+		return nil, err
+	}
+
+	commits := []GitCommit{}
+	for _, record := range strings.Split(out, "\x1e") {
+		fields := strings.Split(strings.TrimSpace(record), "\x1f")
+		if len(fields) != 4 {
+			continue
+		}
+		date, err := time.Parse(time.RFC3339, fields[2])
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, GitCommit{
+			Hash:    fields[0],
+			Author:  fields[1],
+			Date:    date,
+			Message: fields[3],
+		})
+	}
+	return commits, nil
+}
+
+// Unified diff of a page between two commits.
+func (w *Wiki) PageDiff(name, from, to string) (string, error) {
+	if !revisionRe.MatchString(from) || !revisionRe.MatchString(to) {
+		return "", fmt.Errorf("invalid revision")
+	}
+	if !w.isGitRepo() {
+		return "", ErrNotGitRepo
+	}
+	return w.git("diff", from, to, "--", getPageFile(name))
+}
+
+// Commits of a page as JSON.
+func (a *Api) serveHistory(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := a.isValidName(name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !a.mayRead(w, r, name) {
+		return
+	}
+	commits, err := a.wiki.PageHistory(name)
+	if errors.Is(err, ErrNotGitRepo) {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	} else if err != nil {
+		slog.Error("page history", "page", name, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, commits)
+}
+
+// Changes to a page between ?from= and ?to= as a unified diff.
+func (a *Api) serveDiff(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	from, to := r.FormValue("from"), r.FormValue("to")
+	if err := a.isValidName(name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !revisionRe.MatchString(from) || !revisionRe.MatchString(to) {
+		http.Error(w, "from and to must be commit hashes", http.StatusBadRequest)
+		return
+	}
+	if !a.mayRead(w, r, name) {
+		return
+	}
+	diff, err := a.wiki.PageDiff(name, from, to)
+	if errors.Is(err, ErrNotGitRepo) {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	} else if err != nil {
+		slog.Error("page diff", "page", name, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(diff))
+}
+
+var historyTmpl = template.Must(template.New("history").Parse(`
+<h1>History of <a href="{{.Base}}/{{.Name}}">{{.Name}}</a></h1>
+<ul class="history">
+  {{range .Commits}}
+  <li>
+    <code>{{slice .Hash 0 7}}</code> {{.Message}}
+    <small>{{.Author}}, {{.Date.Format "2006-01-02 15:04"}}</small>
+    {{if .Prev}}<a href="{{$.Base}}/api/diff/{{$.Name}}?from={{.Prev}}&to={{.Hash}}">diff</a>{{end}}
+  </li>
+  {{else}}
+  <li>no history</li>
+  {{end}}
+</ul>`))
+
+// A page's commits rendered with the wiki template.
+func (s *Server) serveHistory(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	page, ok := s.wiki.ReadPage(name)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		page404Tmpl.Execute(w, map[string]string{"Name": name, "Base": s.opts.BasePath})
+		return
+	}
+	if !s.wiki.visible(&page, s.canSeePrivate(r)) {
+		requestAuth(w, s.opts.AuthRealm)
+		return
+	}
+	commits, err := s.wiki.PageHistory(name)
+	if errors.Is(err, ErrNotGitRepo) {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	} else if err != nil {
+		slog.Error("page history", "page", name, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// Each commit is diffed against the one before it
+	type entry struct {
+		GitCommit
+		Prev string
+	}
+	entries := make([]entry, len(commits))
+	for i, c := range commits {
+		entries[i].GitCommit = c
+		if i+1 < len(commits) {
+			entries[i].Prev = commits[i+1].Hash
+		}
+	}
+	content, err := renderFragment(historyTmpl, map[string]any{
+		"Base":    s.opts.BasePath,
+		"Name":    name,
+		"Commits": entries,
+	})
+	if err != nil {
+		slog.Error("page history", "page", name, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	s.wiki.mu.RLock()
+	tmpl := s.wiki.Template
+	s.wiki.mu.RUnlock()
+	data := s.pageData(Page{Name: name, Title: "History of " + name}, content)
+	data["URL"] = s.baseURL(r) + "history/" + escapeName(name)
+	if err := tmpl.Execute(w, data); err != nil {
+		slog.Error("page template execute", "error", err)
+	}
+}
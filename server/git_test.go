@@ -0,0 +1,100 @@
+package server
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Like newTestWiki but in a git repo with the pages committed and
+// Options.Git set.
+func newGitWiki(t *testing.T, pages map[string]string) *Wiki {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	wiki := newTestWiki(t, pages)
+	wiki.Options.Git = true
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"config", "user.name", "test"},
+		{"config", "user.email", "test@example.com"},
+		{"add", "-A"},
+		{"commit", "-q", "--allow-empty", "-m", "Start"},
+	} {
+		if _, err := wiki.git(args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return wiki
+}
+
+// Paths git reports as changed but not committed.
+func gitStatus(t *testing.T, wiki *Wiki) string {
+	t.Helper()
+	out, err := wiki.git("status", "--porcelain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(out)
+}
+
+func TestWritePagesBacksUpAndCommits(t *testing.T) {
+	wiki := newGitWiki(t, map[string]string{"old": "# Old\n"})
+	// Backups aren't tracked
+	if err := os.WriteFile(filepath.Join(wiki.Dir, ".gitignore"), []byte("*.bak\n.gitignore\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := wiki.WritePages(map[string]string{"old": "# Changed\n", "new": "# New\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != 1 {
+		t.Errorf("got %d pages created, want 1", created)
+	}
+	if b, _ := os.ReadFile(filepath.Join(wiki.Dir, "old.md.bak")); string(b) != "# Old\n" {
+		t.Errorf("backup is %q, want the old page", b)
+	}
+	if status := gitStatus(t, wiki); status != "" {
+		t.Errorf("pages left uncommitted:\n%s", status)
+	}
+	if history, _ := wiki.PageHistory("new"); len(history) != 1 {
+		t.Errorf("got %d commits of the new page, want 1", len(history))
+	}
+}
+
+func TestRenamePageCommitsRename(t *testing.T) {
+	wiki := newGitWiki(t, map[string]string{"old": "# Old\n\nSome text to recognise the page by.\n"})
+
+	if err := wiki.RenamePage("old", "new", false); err != nil {
+		t.Fatal(err)
+	}
+	if status := gitStatus(t, wiki); status != "" {
+		t.Errorf("rename left uncommitted:\n%s", status)
+	}
+	// The commit adding the page, then the rename
+	history, err := wiki.PageHistory("new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Message != "Rename old to new" {
+		t.Errorf("history of the new name is %+v, want the rename and the commit before it", history)
+	}
+}
+
+func TestDeletePageCommitsRemoval(t *testing.T) {
+	wiki := newGitWiki(t, map[string]string{"gone": "# Gone\n"})
+
+	if err := wiki.DeletePage("gone"); err != nil {
+		t.Fatal(err)
+	}
+	if status := gitStatus(t, wiki); status != "" {
+		t.Errorf("deletion left uncommitted:\n%s", status)
+	}
+	if msg, _ := wiki.git("log", "-1", "--format=%s"); strings.TrimSpace(msg) != "Delete gone" {
+		t.Errorf("last commit is %q, want the deletion", msg)
+	}
+}
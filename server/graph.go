@@ -0,0 +1,219 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"html/template"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+)
+
+// Groups of pages that link to each other in a cycle (A→B→...→A), found as
+// the strongly connected components of the link graph with more than one
+// page. Largest first, each sorted by name. Private pages are left out
+// unless authorized.
+func (w *Wiki) Cycles(authorized bool) [][]string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	// Tarjan's algorithm
+	index := map[string]int{}
+	lowlink := map[string]int{}
+	onStack := map[string]bool{}
+	var stack []string
+	var cycles [][]string
+
+	var connect func(name string)
+	connect = func(name string) {
+		index[name] = len(index)
+		lowlink[name] = index[name]
+		stack = append(stack, name)
+		onStack[name] = true
+
+		for target := range w.Pages[name].Links {
+			if t, ok := w.Pages[target]; !ok || !w.visible(t, authorized) {
+				continue
+			}
+			if _, seen := index[target]; !seen {
+				connect(target)
+				lowlink[name] = min(lowlink[name], lowlink[target])
+			} else if onStack[target] {
+				lowlink[name] = min(lowlink[name], index[target])
+			}
+		}
+
+		// name is the root of a component, pop it off the stack
+		if lowlink[name] == index[name] {
+			var scc []string
+			for {
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[top] = false
+				scc = append(scc, top)
+				if top == name {
+					break
+				}
+			}
+			if len(scc) > 1 {
+				slices.Sort(scc)
+				cycles = append(cycles, scc)
+			}
+		}
+	}
+
+	// Visit in name order so results are deterministic
+	for _, name := range slices.Sorted(maps.Keys(w.Pages)) {
+		if _, seen := index[name]; !seen && w.visible(w.Pages[name], authorized) {
+			connect(name)
+		}
+	}
+
+	slices.SortFunc(cycles, func(a, b []string) int {
+		if c := cmp.Compare(len(b), len(a)); c != 0 {
+			return c
+		}
+		return cmp.Compare(a[0], b[0])
+	})
+	return cycles
+}
+
+// Wikilink targets that don't resolve to a page, keyed by linking page.
+// Pages without broken links, and private pages unless authorized, are
+// omitted.
+func (w *Wiki) BrokenLinks(authorized bool) map[string][]string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	broken := map[string][]string{}
+	for name, p := range w.Pages {
+		if !w.visible(p, authorized) {
+			continue
+		}
+		for target := range p.Links {
+			if _, ok := w.Pages[target]; !ok {
+				broken[name] = append(broken[name], target)
+			}
+		}
+		slices.Sort(broken[name])
+	}
+	return broken
+}
+
+// The wiki's pages and the wikilinks between them, for drawing.
+type LinkGraph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+type GraphNode struct {
+	ID        string `json:"id"` // page name
+	Title     string `json:"title"`
+	Backlinks int    `json:"backlinks"`
+}
+
+type GraphEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// The link graph, sorted by name. Built-in, alias, redirect, draft and
+// private pages are left out, and links to an alias point at its canonical page. Broken
+// links have no node to point at so are dropped.
+func (w *Wiki) Graph() LinkGraph {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	included := func(p *Page) bool {
+		_, builtin := builtinPages[p.Name]
+		return !builtin && p.Alias == "" && p.RedirectTo == "" && !p.hidden && p.IsPublic()
+	}
+
+	g := LinkGraph{Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	for _, name := range slices.Sorted(maps.Keys(w.Pages)) {
+		p := w.Pages[name]
+		if !included(p) {
+			continue
+		}
+		g.Nodes = append(g.Nodes, GraphNode{ID: name, Title: p.Title, Backlinks: len(p.Backlinks)})
+		for _, target := range slices.Sorted(maps.Keys(p.Links)) {
+			t, ok := w.Pages[target]
+			if ok && t.Alias != "" {
+				target = t.Alias
+				t, ok = w.Pages[target]
+			}
+			if ok && included(t) {
+				g.Edges = append(g.Edges, GraphEdge{Source: name, Target: target})
+			}
+		}
+	}
+	return g
+}
+
+// The link graph as JSON. Clients may cache it briefly and revalidate
+// with the ETag.
+func (a *Api) serveGraph(w http.ResponseWriter, r *http.Request) {
+	b, err := json.Marshal(a.wiki.Graph())
+	if err != nil {
+		slog.Error("json encode", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	h := fnv.New64a()
+	h.Write(b)
+	etag := fmt.Sprintf(`"%x"`, h.Sum64())
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// D3 ES module used to draw the graph page
+const d3Module = "https://cdn.jsdelivr.net/npm/d3@7/+esm"
+
+var graphTmpl = template.Must(template.New("graph").Parse(`
+<svg id="graph" width="100%" height="600"></svg>
+<script type="module">
+    import * as d3 from {{.D3}};
+    const base = {{.Base}};
+    const {nodes, edges} = await (await fetch(base + "/api/graph")).json();
+    const svg = d3.select("#graph");
+    const {width, height} = svg.node().getBoundingClientRect();
+    const radius = d => 4 + 2 * Math.sqrt(d.backlinks);
+
+    const link = svg.append("g").attr("class", "edges").selectAll("line")
+        .data(edges).join("line");
+    const node = svg.append("g").attr("class", "nodes").selectAll("a")
+        .data(nodes).join("a").attr("href", d => base + "/" + d.id);
+    node.append("circle").attr("r", radius);
+    node.append("title").text(d => d.title || d.id);
+
+    const simulation = d3.forceSimulation(nodes)
+        .force("link", d3.forceLink(edges).id(d => d.id).distance(60))
+        .force("charge", d3.forceManyBody().strength(-80))
+        .force("collide", d3.forceCollide(d => radius(d) + 2))
+        .force("center", d3.forceCenter(width / 2, height / 2))
+        .on("tick", () => {
+            link.attr("x1", d => d.source.x).attr("y1", d => d.source.y)
+                .attr("x2", d => d.target.x).attr("y2", d => d.target.y);
+            node.attr("transform", d => ` + "`translate(${d.x},${d.y})`" + `);
+        });
+
+    node.call(d3.drag()
+        .on("start", (e, d) => { if (!e.active) simulation.alphaTarget(0.3).restart(); d.fx = d.x; d.fy = d.y; })
+        .on("drag", (e, d) => { d.fx = e.x; d.fy = e.y; })
+        .on("end", (e, d) => { if (!e.active) simulation.alphaTarget(0); d.fx = d.fy = null; }));
+</script>`))
+
+// An interactive force-directed drawing of the link graph, bigger
+// circles having more backlinks
+func (s *Server) graphContent(r *http.Request) (template.HTML, error) {
+	return renderFragment(graphTmpl, map[string]string{"D3": d3Module, "Base": s.opts.BasePath})
+}
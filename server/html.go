@@ -0,0 +1,180 @@
+package server
+
+import (
+	"bytes"
+	"html/template"
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Parse rendered page HTML, call fn on every element then render it again.
+// fn is called on an element before its children so it may remove them,
+// starting with a <body> holding the fragment. The input is returned
+// unchanged if it can't be parsed.
+func transformHTML(src template.HTML, fn func(n *html.Node)) template.HTML {
+	body := &html.Node{Type: html.ElementNode, DataAtom: atom.Body, Data: "body"}
+	nodes, err := html.ParseFragment(strings.NewReader(string(src)), body)
+	if err != nil {
+		return src
+	}
+	for _, n := range nodes {
+		body.AppendChild(n)
+	}
+	walkElements(body, fn)
+
+	var sb strings.Builder
+	for n := body.FirstChild; n != nil; n = n.NextSibling {
+		if err := html.Render(&sb, n); err != nil {
+			return src
+		}
+	}
+	return template.HTML(sb.String())
+}
+
+// Like transformHTML but for a whole document, e.g. an executed template.
+func transformDocument(src []byte, fn func(n *html.Node)) ([]byte, error) {
+	doc, err := html.Parse(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	walkElements(doc, fn)
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Call fn on n and every element beneath it.
+func walkElements(n *html.Node, fn func(n *html.Node)) {
+	if n.Type == html.ElementNode {
+		fn(n)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walkElements(c, fn)
+	}
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// The value of an attribute, empty if it isn't set.
+func attrValue(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// Add loading="lazy" to every <img> that doesn't set loading itself.
+func addLazyLoading(src template.HTML) template.HTML {
+	return transformHTML(src, func(n *html.Node) {
+		if n.DataAtom == atom.Img && !hasAttr(n, "loading") {
+			n.Attr = append(n.Attr, html.Attribute{Key: "loading", Val: "lazy"})
+		}
+	})
+}
+
+// Elements removed by sanitizeHTML, with everything inside them.
+var unsafeElements = []string{"script", "iframe", "frame", "object", "embed"}
+
+// Attributes whose value is a URL that could be javascript:
+var urlAttrs = []string{"href", "src", "action", "formaction", "xlink:href"}
+
+// Remove scripts, frames, event handler attributes like onclick,
+// javascript: URLs and animations of URLs from HTML written into pages.
+func sanitizeHTML(src template.HTML) template.HTML {
+	return transformHTML(src, func(n *html.Node) {
+		for c := n.FirstChild; c != nil; {
+			next := c.NextSibling
+			if c.Type == html.ElementNode && (slices.Contains(unsafeElements, strings.ToLower(c.Data)) || isURLAnimation(c)) {
+				n.RemoveChild(c)
+			}
+			c = next
+		}
+		n.Attr = slices.DeleteFunc(n.Attr, func(a html.Attribute) bool {
+			key := strings.ToLower(a.Key)
+			if a.Namespace != "" {
+				key = a.Namespace + ":" + key
+			}
+			return strings.HasPrefix(key, "on") ||
+				slices.Contains(urlAttrs, key) && isJavaScriptURL(a.Val)
+		})
+	})
+}
+
+// Whether n is an SVG <animate> or <set> changing a link's URL, which
+// could set it to javascript: after sanitizing.
+func isURLAnimation(n *html.Node) bool {
+	if name := strings.ToLower(n.Data); name != "animate" && name != "set" {
+		return false
+	}
+	for _, a := range n.Attr {
+		if strings.EqualFold(a.Key, "attributeName") {
+			target := strings.ToLower(strings.TrimSpace(a.Val))
+			return target == "href" || target == "xlink:href"
+		}
+	}
+	return false
+}
+
+// Whether a URL uses the javascript: scheme. Browsers ignore whitespace
+// and control characters in it, so this does too.
+func isJavaScriptURL(s string) bool {
+	s = strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, s)
+	return len(s) >= len("javascript:") && strings.EqualFold(s[:len("javascript:")], "javascript:")
+}
+
+// Prefix relative links and image sources with prefix, e.g. "../" so
+// that links in notes/foo still resolve from the wiki root.
+func rebaseLinks(src template.HTML, prefix string) template.HTML {
+	return transformHTML(src, func(n *html.Node) {
+		for i, a := range n.Attr {
+			if a.Key != "href" && a.Key != "src" {
+				continue
+			}
+			u, err := url.Parse(a.Val)
+			if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || strings.HasPrefix(u.Path, "/") {
+				continue
+			}
+			n.Attr[i].Val = prefix + a.Val
+		}
+	})
+}
+
+// Add class="broken" to links whose href is one of the given page names.
+func markBrokenLinks(src template.HTML, targets []string) template.HTML {
+	return transformHTML(src, func(n *html.Node) {
+		if n.DataAtom != atom.A {
+			return
+		}
+		for _, a := range n.Attr {
+			if a.Key != "href" {
+				continue
+			}
+			// The page, without any #section
+			u, err := url.Parse(a.Val)
+			if err == nil && slices.Contains(targets, u.Path) {
+				n.Attr = append(n.Attr, html.Attribute{Key: "class", Val: "broken"})
+			}
+			return
+		}
+	})
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"html/template"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		in      string
+		removed string // must not survive sanitizing
+		kept    string // must survive
+	}{
+		{`<p>hi<script>alert(1)</script></p>`, "<script", "<p>hi</p>"},
+		{`<a href="javascript:alert(1)">x</a>`, "javascript:", "<a>x</a>"},
+		{`<img src="a.png" onerror="alert(1)">`, "onerror", `src="a.png"`},
+		{
+			`<svg><a><animate attributeName="href" values="javascript:alert(1)"/><text>x</text></a></svg>`,
+			"javascript:", "<text>x</text>",
+		},
+		{
+			`<svg><a><set attributeName="xlink:href" to="javascript:alert(1)"/><text>x</text></a></svg>`,
+			"javascript:", "<text>x</text>",
+		},
+		{`<svg><animate attributeName="opacity" values="0;1"/></svg>`, "javascript:", `attributeName="opacity"`},
+	}
+	for _, tt := range tests {
+		got := string(sanitizeHTML(template.HTML(tt.in)))
+		if strings.Contains(got, tt.removed) {
+			t.Errorf("sanitizing %s: %q survived in %s", tt.in, tt.removed, got)
+		}
+		if !strings.Contains(got, tt.kept) {
+			t.Errorf("sanitizing %s: %q lost from %s", tt.in, tt.kept, got)
+		}
+	}
+}
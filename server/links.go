@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The outcome of checking one external link that failed.
+type LinkCheckResult struct {
+	PageName   string
+	URL        string
+	StatusCode int    // zero if the request failed outright
+	Error      string // set if the request failed outright
+}
+
+// Issue a HEAD request for every external link in the wiki and report
+// those that errored or returned a non-2xx status.
+func (w *Wiki) CheckExternalLinks(ctx context.Context, timeout time.Duration) []LinkCheckResult {
+	type check struct{ page, url string }
+	var checks []check
+	w.mu.RLock()
+	for name, p := range w.Pages {
+		for _, u := range p.ExternalLinks {
+			checks = append(checks, check{name, u})
+		}
+	}
+	w.mu.RUnlock()
+
+	limit := w.Options.LinkCheckConcurrency
+	if limit <= 0 {
+		limit = 8
+	}
+	sem := make(chan struct{}, limit)
+	client := &http.Client{Timeout: timeout}
+
+	var mu sync.Mutex
+	var results []LinkCheckResult
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			res := LinkCheckResult{PageName: c.page, URL: c.url}
+			code, err := headStatus(ctx, client, c.url)
+			if err != nil {
+				res.Error = err.Error()
+			} else if code < 200 || code > 299 {
+				res.StatusCode = code
+			} else {
+				return
+			}
+			mu.Lock()
+			results = append(results, res)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	slices.SortFunc(results, func(a, b LinkCheckResult) int {
+		if c := strings.Compare(a.PageName, b.PageName); c != 0 {
+			return c
+		}
+		return strings.Compare(a.URL, b.URL)
+	})
+	return results
+}
+
+func headStatus(ctx context.Context, client *http.Client, url string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// Human readable description of a failed check.
+func (r LinkCheckResult) String() string {
+	if r.Error != "" {
+		return fmt.Sprintf("%s: %s: %s", r.PageName, r.URL, r.Error)
+	}
+	return fmt.Sprintf("%s: %s: status %d", r.PageName, r.URL, r.StatusCode)
+}
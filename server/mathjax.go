@@ -0,0 +1,152 @@
+package server
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Address of the MathJax script loaded by pages with maths.
+const mathJaxCDN = "https://cdn.jsdelivr.net/npm/mathjax@3/es5/tex-chtml.js"
+
+// A $$ block of TeX on lines between $$ delimiters.
+type mathBlock struct {
+	ast.BaseBlock
+}
+
+var kindMathBlock = ast.NewNodeKind("MathBlock")
+
+func (n *mathBlock) Kind() ast.NodeKind { return kindMathBlock }
+
+func (n *mathBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// Inline $TeX$, or $$TeX$$ displayed on its own line.
+type mathInline struct {
+	ast.BaseInline
+	Value   text.Segment
+	Display bool
+}
+
+var kindMathInline = ast.NewNodeKind("MathInline")
+
+func (n *mathInline) Kind() ast.NodeKind { return kindMathInline }
+
+func (n *mathInline) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// Renders $ and $$ maths with MathJax's \( \) and \[ \] delimiters for
+// it to typeset in the browser.
+type mathJaxExtender struct{}
+
+func (mathJaxExtender) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(util.Prioritized(mathBlockParser{}, 100)),
+		parser.WithInlineParsers(util.Prioritized(mathInlineParser{}, 100)),
+	)
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(mathRenderer{}, 100),
+	))
+}
+
+type mathBlockParser struct{}
+
+func (mathBlockParser) Trigger() []byte { return []byte{'$'} }
+
+func (mathBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, _ := reader.PeekLine()
+	if !isMathFence(line) {
+		return nil, parser.NoChildren
+	}
+	return &mathBlock{}, parser.NoChildren
+}
+
+func (mathBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	line, segment := reader.PeekLine()
+	if isMathFence(line) {
+		reader.Advance(segment.Len())
+		return parser.Close
+	}
+	node.Lines().Append(segment)
+	reader.Advance(segment.Len() - 1)
+	return parser.Continue | parser.NoChildren
+}
+
+func (mathBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (mathBlockParser) CanInterruptParagraph() bool { return true }
+
+func (mathBlockParser) CanAcceptIndentedLine() bool { return false }
+
+// Whether line is just $$, opening or closing a mathBlock.
+func isMathFence(line []byte) bool {
+	return bytes.Equal(util.TrimRightSpace(util.TrimLeftSpace(line)), []byte("$$"))
+}
+
+type mathInlineParser struct{}
+
+func (mathInlineParser) Trigger() []byte { return []byte{'$'} }
+
+// Maths must close on the same line. Like Pandoc, $ maths can't start
+// or end with a space, so prices like $5 and $10 stay as text.
+func (mathInlineParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, segment := block.PeekLine()
+	delim := []byte("$")
+	if bytes.HasPrefix(line, []byte("$$")) {
+		delim = []byte("$$")
+	}
+	body := line[len(delim):]
+	end := bytes.Index(body, delim)
+	if end < 1 {
+		return nil
+	}
+	if len(delim) == 1 && (util.IsSpace(body[0]) || util.IsSpace(body[end-1])) {
+		return nil
+	}
+	start := segment.Start + len(delim)
+	block.Advance(len(delim) + end + len(delim))
+	return &mathInline{Value: text.NewSegment(start, start+end), Display: len(delim) == 2}
+}
+
+type mathRenderer struct{}
+
+func (mathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMathBlock, renderMathBlock)
+	reg.Register(kindMathInline, renderMathInline)
+}
+
+func renderMathBlock(w util.BufWriter, src []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	w.WriteString(`<div class="math">\[`)
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		w.Write(util.EscapeHTML(line.Value(src)))
+	}
+	w.WriteString("\\]</div>\n")
+	return ast.WalkSkipChildren, nil
+}
+
+func renderMathInline(w util.BufWriter, src []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	m := n.(*mathInline)
+	open, close := `\(`, `\)`
+	if m.Display {
+		open, close = `\[`, `\]`
+	}
+	w.WriteString(`<span class="math">` + open)
+	w.Write(util.EscapeHTML(m.Value.Value(src)))
+	w.WriteString(close + "</span>")
+	return ast.WalkSkipChildren, nil
+}
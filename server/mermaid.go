@@ -0,0 +1,78 @@
+package server
+
+import (
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Default address of the Mermaid ES module, see ServeOptions.MermaidCDN
+const DefaultMermaidCDN = "https://cdn.jsdelivr.net/npm/mermaid@11/dist/mermaid.esm.min.mjs"
+
+// A ```mermaid fenced code block, rendered for Mermaid to draw in the browser.
+type mermaidBlock struct {
+	ast.BaseBlock
+}
+
+var kindMermaidBlock = ast.NewNodeKind("MermaidBlock")
+
+func (n *mermaidBlock) Kind() ast.NodeKind { return kindMermaidBlock }
+
+func (n *mermaidBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// Turns ```mermaid blocks into <div class="mermaid"> rather than code.
+type mermaidExtender struct{}
+
+func (mermaidExtender) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(
+		util.Prioritized(mermaidTransformer{}, 100),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(mermaidRenderer{}, 100),
+	))
+}
+
+type mermaidTransformer struct{}
+
+// Swap mermaid code blocks for mermaidBlocks with the same lines, so
+// other code blocks are still rendered (and highlighted) as normal.
+func (mermaidTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	src := reader.Source()
+	var blocks []*ast.FencedCodeBlock
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if b, ok := n.(*ast.FencedCodeBlock); ok && entering && string(b.Language(src)) == "mermaid" {
+			blocks = append(blocks, b)
+		}
+		return ast.WalkContinue, nil
+	})
+	for _, b := range blocks {
+		m := &mermaidBlock{}
+		m.SetLines(b.Lines())
+		b.Parent().ReplaceChild(b.Parent(), b, m)
+	}
+}
+
+type mermaidRenderer struct{}
+
+func (mermaidRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMermaidBlock, renderMermaid)
+}
+
+func renderMermaid(w util.BufWriter, src []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	w.WriteString(`<div class="mermaid">`)
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		w.Write(util.EscapeHTML(line.Value(src)))
+	}
+	w.WriteString("</div>\n")
+	return ast.WalkSkipChildren, nil
+}
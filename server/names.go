@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Patterns for page names, shared with the browser for client-side checks.
+// A name can't start with a dot so it can never be hidden or relative.
+const (
+	PageNamePattern        = `[a-zA-Z0-9_+\-][a-zA-Z0-9_.+\-]*`
+	PageNamePatternUnicode = `[\p{L}\p{Nd}_+\-][\p{L}\p{Nd}_.+\-]*`
+)
+
+var nameRe = regexp.MustCompile("^" + PageNamePattern + "$")
+
+// Whether name is safe to use as a page (and so file) name.
+func IsValidPageName(name string) bool {
+	return nameRe.MatchString(name)
+}
+
+// Like IsValidPageName but allows any Unicode letters and digits.
+// Accepts the same names as PageNamePatternUnicode, checked rune by
+// rune as that's much cheaper than the regexp.
+func IsValidPageNameUnicode(name string) bool {
+	if name == "" || name[0] == '.' {
+		return false
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("_.+-", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Whether name is a slash separated path of valid page names, in either
+// alphabet, for names that don't come from a request, like redirects.
+func isValidPagePath(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if !IsValidPageNameUnicode(part) {
+			return false
+		}
+	}
+	return true
+}
+
+// A validator accepting names that entirely match a regular expression.
+func PatternValidator(pattern string) (func(string) bool, error) {
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, err
+	}
+	return re.MatchString, nil
+}
+
+// A pattern matching slash separated names whose every part matches pattern.
+func pathPattern(pattern string) string {
+	return "(?:" + pattern + ")(?:/(?:" + pattern + "))*"
+}
+
+// A page name escaped for a URL path, keeping the slashes between its parts.
+func escapeName(name string) string {
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,18 @@
+package server
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestIsValidPageNameUnicodeMatchesPattern(t *testing.T) {
+	re := regexp.MustCompile("^" + PageNamePatternUnicode + "$")
+	for _, name := range []string{
+		"", "foo", "Foo_bar-1.2", "+1", "-", ".hidden", "a.", "a/b", "a b",
+		"café", "日本語", "٣٤", "naïve.md", "x y", "emoji😀", "a\x00", "\xff",
+	} {
+		if got, want := IsValidPageNameUnicode(name), re.MatchString(name); got != want {
+			t.Errorf("IsValidPageNameUnicode(%q) = %v, the pattern says %v", name, got, want)
+		}
+	}
+}
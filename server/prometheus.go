@@ -0,0 +1,107 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus collectors for a served wiki, see ServeOptions.Metrics.
+type promMetrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+}
+
+func newPromMetrics(wiki *Wiki) *promMetrics {
+	m := &promMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "candl_http_requests_total",
+			Help: "HTTP requests by method, status and route.",
+		}, []string{"method", "status", "path"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "candl_http_request_duration_seconds",
+			Help:    "Time taken to answer HTTP requests by method and route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "path"}),
+	}
+	reloadDuration := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "candl_wiki_reload_duration_seconds",
+		Help:    "Time taken by full wiki reloads.",
+		Buckets: prometheus.DefBuckets,
+	})
+	wiki.observeReload = func(d time.Duration) {
+		reloadDuration.Observe(d.Seconds())
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		reloadDuration,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "candl_pages_total",
+			Help: "Pages currently loaded.",
+		}, func() float64 {
+			wiki.mu.RLock()
+			defer wiki.mu.RUnlock()
+			return float64(len(wiki.Pages))
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "candl_wiki_reload_total",
+			Help: "Full wiki reloads.",
+		}, func() float64 {
+			return float64(wiki.Metrics.ReloadCount.Load())
+		}),
+	)
+	return m
+}
+
+// Serve the collected metrics in the Prometheus text format.
+func (m *promMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Count and time every request handled by next.
+func (m *promMetrics) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		// The mux fills in the matched pattern, which keeps the number of
+		// label values small unlike the raw path.
+		path := r.Pattern
+		if path == "" {
+			path = "unmatched"
+		}
+		m.requests.WithLabelValues(r.Method, strconv.Itoa(rec.status), path).Inc()
+		m.requestDuration.WithLabelValues(r.Method, path).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Needed by the /ws/reload websocket upgrade.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r.status = http.StatusSwitchingProtocols
+	return http.NewResponseController(r.ResponseWriter).Hijack()
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
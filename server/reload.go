@@ -0,0 +1,61 @@
+package server
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Browsers connected to /ws/reload, told which page to reload when the
+// watcher picks up a change.
+type reloadHub struct {
+	mu      sync.Mutex
+	clients map[*websocket.Conn]struct{}
+}
+
+func newReloadHub() *reloadHub {
+	return &reloadHub{clients: map[*websocket.Conn]struct{}{}}
+}
+
+// Hold a connection open until the browser goes away.
+func (h *reloadHub) serve(ws *websocket.Conn) {
+	h.mu.Lock()
+	h.clients[ws] = struct{}{}
+	h.mu.Unlock()
+
+	// Clients never send anything, so reading only returns on close
+	var msg string
+	for websocket.Message.Receive(ws, &msg) == nil {
+	}
+
+	h.mu.Lock()
+	delete(h.clients, ws)
+	h.mu.Unlock()
+	ws.Close()
+}
+
+// Send a page name, or "*" for every page, to all connected browsers.
+func (h *reloadHub) broadcast(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ws := range h.clients {
+		ws.SetWriteDeadline(time.Now().Add(time.Second))
+		if err := websocket.Message.Send(ws, name); err != nil {
+			slog.Debug("dropping reload client", "error", err)
+			delete(h.clients, ws)
+			ws.Close()
+		}
+	}
+}
+
+// Disconnect every browser, e.g. when the server shuts down.
+func (h *reloadHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ws := range h.clients {
+		ws.Close()
+		delete(h.clients, ws)
+	}
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// A page as returned by GET /api/v1/pages/{name}
+type pageJSON struct {
+	Name      string   `json:"name"`
+	Title     string   `json:"title"`
+	HTML      string   `json:"html"`
+	Raw       string   `json:"raw"`
+	Backlinks []string `json:"backlinks"`
+	Links     []string `json:"links"`
+}
+
+// Body of POST /api/v1/pages and PUT /api/v1/pages/{name}. A PUT with a
+// different name renames the page first.
+type pageWrite struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+// An error response from the v1 API
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// List every page's name and title.
+func (a *Api) serveV1List(w http.ResponseWriter, r *http.Request) {
+	type entry struct {
+		Name  string `json:"name"`
+		Title string `json:"title"`
+	}
+	authorized := a.canSeePrivate(r)
+	a.wiki.mu.RLock()
+	pages := make([]entry, 0, len(a.wiki.Pages))
+	for _, p := range a.wiki.Pages {
+		if !p.hidden && a.wiki.visible(p, authorized) {
+			pages = append(pages, entry{Name: p.Name, Title: p.Title})
+		}
+	}
+	a.wiki.mu.RUnlock()
+
+	slices.SortFunc(pages, func(a, b entry) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	writeJSON(w, http.StatusOK, pages)
+}
+
+// Create a page, failing if it already exists.
+func (a *Api) serveV1Create(w http.ResponseWriter, r *http.Request) {
+	if !isJSON(r) {
+		writeJSON(w, http.StatusUnsupportedMediaType, apiError{"Content-Type must be application/json"})
+		return
+	}
+	var req pageWrite
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{err.Error()})
+		return
+	}
+	if err := a.isValidName(req.Name); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{err.Error()})
+		return
+	}
+	// Built-in pages and aliases have no file so may be created
+	if p, ok := a.wiki.ReadPage(req.Name); ok && !p.ModTime.IsZero() {
+		writeJSON(w, http.StatusConflict, apiError{ErrPageExists.Error()})
+		return
+	}
+
+	if !a.writeV1(w, req.Name, req.Content) {
+		return
+	}
+	a.serveV1Page(w, r, req.Name, http.StatusCreated)
+}
+
+// Return a single page.
+func (a *Api) serveV1Get(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !a.mayRead(w, r, name) {
+		return
+	}
+	a.serveV1Page(w, r, name, http.StatusOK)
+}
+
+// Replace a page's content, creating it if needed.
+func (a *Api) serveV1Put(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !isJSON(r) {
+		writeJSON(w, http.StatusUnsupportedMediaType, apiError{"Content-Type must be application/json"})
+		return
+	}
+	var req pageWrite
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{err.Error()})
+		return
+	}
+	if err := a.isValidName(name); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{err.Error()})
+		return
+	}
+	if !a.mayRead(w, r, name) || !a.mayRead(w, r, req.Name) {
+		return
+	}
+	if !a.wiki.CheckEditLock(name, "") {
+		writeJSON(w, http.StatusConflict, apiError{"page is being edited"})
+		return
+	}
+
+	_, existed := a.wiki.ReadPage(name)
+	if req.Name != "" && req.Name != name {
+		if err := a.isValidName(req.Name); err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{err.Error()})
+			return
+		}
+		if err := a.wiki.RenamePage(name, req.Name, false); errors.Is(err, ErrPageExists) {
+			writeJSON(w, http.StatusConflict, apiError{err.Error()})
+			return
+		} else if errors.Is(err, fs.ErrNotExist) {
+			writeJSON(w, http.StatusNotFound, apiError{"page not found"})
+			return
+		} else if err != nil {
+			writeJSON(w, http.StatusInternalServerError, apiError{err.Error()})
+			return
+		}
+		name = req.Name
+	}
+
+	if !a.writeV1(w, name, req.Content) {
+		return
+	}
+	status := http.StatusOK
+	if !existed {
+		status = http.StatusCreated
+	}
+	a.serveV1Page(w, r, name, status)
+}
+
+// Delete a page.
+func (a *Api) serveV1Delete(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := a.isValidName(name); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{err.Error()})
+		return
+	}
+	if !a.mayRead(w, r, name) {
+		return
+	}
+	if !a.wiki.CheckEditLock(name, "") {
+		writeJSON(w, http.StatusConflict, apiError{"page is being edited"})
+		return
+	}
+	if err := a.wiki.DeletePage(name); errors.Is(err, fs.ErrNotExist) {
+		writeJSON(w, http.StatusNotFound, apiError{"page not found"})
+		return
+	} else if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Write and reload a page, reporting any failure. Returns whether it worked.
+func (a *Api) writeV1(w http.ResponseWriter, name, content string) bool {
+	content = strings.TrimPrefix(content, utf8BOM)
+	if err := a.wiki.WritePage(name, content); err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{err.Error()})
+		return false
+	}
+	if err := a.wiki.UpdateSingle(name); err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{err.Error()})
+		return false
+	}
+	return true
+}
+
+// Write page name as JSON, leaving out backlinks r may not see.
+func (a *Api) serveV1Page(w http.ResponseWriter, r *http.Request, name string, status int) {
+	p, ok := a.wiki.ReadPage(name)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, apiError{"page not found"})
+		return
+	}
+	a.wiki.mu.RLock()
+	backlinks := a.wiki.visibleNames(p.Backlinks, a.canSeePrivate(r))
+	a.wiki.mu.RUnlock()
+	res := pageJSON{
+		Name:      p.Name,
+		Title:     p.Title,
+		HTML:      string(p.HTML),
+		Raw:       p.Raw,
+		Backlinks: backlinks,
+		Links:     slices.Sorted(maps.Keys(p.Links)),
+	}
+	// Empty arrays rather than null
+	if res.Backlinks == nil {
+		res.Backlinks = []string{}
+	}
+	if res.Links == nil {
+		res.Links = []string{}
+	}
+	writeJSON(w, status, res)
+}
@@ -3,14 +3,25 @@ package server
 import (
 	"context"
 	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
+	"io/fs"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/websocket"
 )
 
 //go:embed 404.html
@@ -19,7 +30,36 @@ var page404Tmpl = template.Must(template.New("404").Parse(page404))
 
 // Server wraps and handles a wiki
 type Server struct {
-	wiki *Wiki
+	wiki   *Wiki
+	opts   ServeOptions
+	reload *reloadHub // browsers waiting to reload on changes
+
+	startedAt  time.Time                // for the uptime reported by /healthz
+	authorized func(*http.Request) bool // whether a request may see private pages
+}
+
+// Settings for serving a wiki over HTTP.
+type ServeOptions struct {
+	Port            string            // 0 picks a free port, logged once listening
+	Watch           bool              // watch directory for changes
+	Debounce        time.Duration     // quiet time before the watcher reloads, see WatchDir
+	NoResourceHints bool              // don't send Link preload headers
+	NameValidator   func(string) bool // used by the edit API, see Api
+	NamePattern     string            // NameValidator as a regex for the browser
+	Title           string            // wiki name for pages and the feed, defaults to the directory name
+	BaseURL         string            // public address of the wiki, needed for the sitemap
+	Metrics         bool              // serve Prometheus metrics at /metrics
+	ShutdownTimeout time.Duration     // how long to let requests finish on shutdown, zero waits forever
+	BasePath        string            // path prefix when behind a reverse proxy, e.g. /wiki
+	MermaidCDN      string            // Mermaid module loaded by pages with diagrams, empty disables
+	TLSCert         string            // certificate file, with TLSKey serves HTTPS
+	TLSKey          string            // private key file for TLSCert
+	ACMEDomain      string            // serve HTTPS with a Let's Encrypt certificate for this domain
+	Auth            map[string]string // if set, Basic auth passwords by user name
+	AuthRealm       string            // realm in the Basic auth challenge
+	AuthPrivateOnly bool              // only ask for Auth credentials on private pages
+	Wiki            Options
+	WatchOptions    WatchOptions
 }
 
 // defaultTemplate is used if template.html not found in wiki dir.
@@ -32,18 +72,54 @@ var defaultTemplate string
 //go:embed style.css
 var defaultStyle string
 
-func NewWiki(dir string) (*Wiki, error) {
+// defaultFavicon is used if _static/favicon.ico not found in wiki dir.
+//
+//go:embed favicon.svg
+var defaultFavicon string
+
+func NewWiki(dir string, opts Options) (*Wiki, error) {
 	templ, err := getTemplate(dir)
 	if err != nil {
 		return nil, err
 	}
+	style, err := loadStyle(dir, opts.HighlightStyle)
+	if err != nil {
+		return nil, err
+	}
+	md, err := newMarkdown(opts)
+	if err != nil {
+		return nil, err
+	}
 	return &Wiki{
 		Pages:    map[string]*Page{},
 		Template: templ,
+		loaded:   time.Now(),
+		Style:    style,
 		Dir:      dir,
+		FS:       os.DirFS(dir),
+		Options:  opts,
+
+		md:        md,
+		index:     searchIndex{},
+		EditLocks: map[string]EditLock{},
+		reloadSem: make(chan struct{}, 1),
 	}, nil
 }
 
+// Functions available to the page template.
+var pageFuncs = template.FuncMap{
+	"byLinkCount": byLinkCount,
+}
+
+// Names sorted by most links first, keeping their order otherwise.
+func byLinkCount(names []string, counts map[string]int) []string {
+	names = slices.Clone(names)
+	slices.SortStableFunc(names, func(a, b string) int {
+		return counts[b] - counts[a]
+	})
+	return names
+}
+
 // Get template from $WIKI/template.html or use embedded default.
 func getTemplate(dir string) (*template.Template, error) {
 	p := filepath.Join(dir, "template.html")
@@ -57,7 +133,7 @@ func getTemplate(dir string) (*template.Template, error) {
 	} else {
 		src = defaultTemplate
 	}
-	tmpl, err := template.New("page").Parse(src)
+	tmpl, err := template.New("page").Funcs(pageFuncs).Parse(src)
 	if err != nil {
 		return nil, err
 	}
@@ -77,48 +153,346 @@ func GetStyle(dir string) (string, error) {
 	return defaultStyle, nil
 }
 
+// The highlighting stylesheet followed by GetStyle, so that a custom
+// style.css can override the highlighting colours.
+func loadStyle(dir string, highlightStyle string) (string, error) {
+	css, err := highlightCSS(highlightStyle)
+	if err != nil {
+		return "", err
+	}
+	style, err := GetStyle(dir)
+	if err != nil {
+		return "", err
+	}
+	return css + style, nil
+}
+
+// Re-read the template and style from the wiki dir.
+func (w *Wiki) ReloadTemplate() error {
+	templ, err := getTemplate(w.Dir)
+	if err != nil {
+		return err
+	}
+	style, err := loadStyle(w.Dir, w.Options.HighlightStyle)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.Template = templ
+	w.loaded = time.Now()
+	w.Style = style
+	return nil
+}
+
 // The handler for all wiki pages
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	name := r.PathValue("name")
 
+	page, ok := s.wiki.ReadPage(name)
+	if !ok {
+		// e.g. /my%20page resolves to my-page
+		page, ok = s.wiki.ReadPage(s.wiki.normalizeName(name))
+	}
+	if !ok {
+		// e.g. /Foo finds foo, redirecting unless that's ambiguous
+		matches := s.wiki.namesEqualFold(name)
+		if len(matches) == 1 {
+			http.Redirect(w, r, s.url("/"+escapeName(matches[0])), http.StatusMovedPermanently)
+			return
+		} else if len(matches) > 1 {
+			slog.Warn("page names differ only in case", "name", name, "matches", matches)
+			page, ok = s.wiki.ReadPage(matches[0])
+		}
+	}
 	s.wiki.mu.RLock()
-	page, ok := s.wiki.Pages[name]
+	tmpl, loaded := s.wiki.Template, s.wiki.loaded
 	s.wiki.mu.RUnlock()
-	// NOTE: Is it ok to unlock at this point? Couldn't page be edited or is that fine?
 	if !ok {
 		w.WriteHeader(http.StatusNotFound)
-		page404Tmpl.Execute(w, name)
+		page404Tmpl.Execute(w, map[string]string{"Name": name, "Base": s.opts.BasePath})
 		return
 	}
+	if page.Alias != "" {
+		http.Redirect(w, r, s.url("/"+escapeName(page.Alias)), http.StatusMovedPermanently)
+		return
+	}
+	if page.RedirectTo != "" {
+		http.Redirect(w, r, s.url("/"+escapeName(page.RedirectTo)), http.StatusMovedPermanently)
+		return
+	}
+
+	authorized := s.canSeePrivate(r)
+	if !s.wiki.visible(&page, authorized) {
+		requestAuth(w, s.opts.AuthRealm)
+		return
+	}
+	s.wiki.mu.RLock()
+	page.Backlinks = s.wiki.visibleNames(page.Backlinks, authorized)
+	s.wiki.mu.RUnlock()
+
+	setCanonicalLink(w, s.baseURL(r), page.Name)
+
+	content := page.HTML
+	if generate, ok := generatedPages[page.Name]; ok {
+		extra, err := generate(s, r)
+		if err != nil {
+			slog.Error("page generate", "page", page.Name, "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		content += extra
+	} else {
+		// Generated pages change without the page changing so can't be cached
+		version := strconv.FormatInt(loaded.UnixNano(), 36)
+		if authorized {
+			version += "a" // backlinks from private pages are shown
+		}
+		etag := fmt.Sprintf(`"%s-%s"`, page.ETag, version)
+		w.Header().Set("ETag", etag)
+		if !page.ModTime.IsZero() {
+			w.Header().Set("Last-Modified", page.ModTime.UTC().Format(http.TimeFormat))
+		}
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
 
-	if err := s.wiki.Template.Execute(w, map[string]interface{}{
-		"Name":      page.Name,
-		"Title":     page.Title,
-		"Content":   page.HTML,
-		"Backlinks": page.Backlinks,
-		"Date":      time.Now().Format("2006-01-02"),
-	}); err != nil {
+	if !s.opts.NoResourceHints {
+		w.Header().Add("Link", "<"+s.url("/style.css")+">; rel=preload; as=style")
+	}
+	data := s.pageData(page, content)
+	data["URL"] = s.baseURL(r) + escapeName(page.Name)
+	if err := tmpl.Execute(w, data); err != nil {
 		slog.Error("page template execute", "error", err)
 	}
 }
 
+// Whether r may see private pages. Exports have no checker so may not.
+func (s *Server) canSeePrivate(r *http.Request) bool {
+	return s.authorized != nil && s.authorized(r)
+}
+
+// The wiki's public address ending in a slash. Taken from the request
+// unless -base-url is set.
+func (s *Server) baseURL(r *http.Request) string {
+	if s.opts.BaseURL != "" {
+		return strings.TrimSuffix(s.opts.BaseURL, "/") + "/"
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + s.url("/")
+}
+
+// Tell crawlers a page's primary address, whatever name reached it.
+// baseURL must end in a slash, see Server.baseURL.
+func setCanonicalLink(w http.ResponseWriter, baseURL, name string) {
+	w.Header().Add("Link", "<"+baseURL+escapeName(name)+`>; rel="canonical"`)
+}
+
+// Whether an If-None-Match header lists etag.
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// A path on this server, under -base-path if set.
+func (s *Server) url(p string) string {
+	return s.opts.BasePath + p
+}
+
+// Where to load Mermaid from if content has a diagram, else "".
+func (s *Server) mermaidURL(content template.HTML) string {
+	if !strings.Contains(string(content), `<div class="mermaid">`) {
+		return ""
+	}
+	return s.opts.MermaidCDN
+}
+
+// Where to load MathJax from if content has maths, else "".
+func (s *Server) mathJaxURL(content template.HTML) string {
+	if !strings.Contains(string(content), `<span class="math">`) && !strings.Contains(string(content), `<div class="math">`) {
+		return ""
+	}
+	return mathJaxCDN
+}
+
+// What the wiki template is executed with for a page.
+func (s *Server) pageData(page Page, content template.HTML) map[string]interface{} {
+	return map[string]interface{}{
+		"Base":           s.opts.BasePath,
+		"Mermaid":        s.mermaidURL(content),
+		"MathJax":        s.mathJaxURL(content),
+		"Name":           page.Name,
+		"Title":          page.Title,
+		"WikiTitle":      s.opts.Title,
+		"Content":        content,
+		"Backlinks":      page.Backlinks,
+		"BacklinkCounts": page.BacklinkCounts,
+		"Excerpt":        page.Excerpt,
+		"Description":    page.Description,
+		"ReadingTime":    page.ReadingTime,
+		"Generated":      page.ModTime.IsZero(), // built in, or a tag or history listing
+		"Meta":           page.Meta,
+		"TOC":            page.TOC,
+		"Tags":           page.Tags,
+		"Date":           time.Now().Format("2006-01-02"),
+		"LiveReload":     s.opts.Watch,
+	}
+}
+
+// Settings for watching a wiki directory.
+type WatchOptions struct {
+	StatusFile   string            // if set, a JSON status is written here after each reload
+	IncludeGlobs []string          // base names that trigger a reload, see defaultWatchGlobs
+	ExcludeGlobs []string          // base names that never trigger a reload
+	OnReload     func(name string) // after a reload, with the changed page or "*" for all
+}
+
+var defaultWatchGlobs = []string{"*.md", "template.html", "style.css"}
+
+// Whether a change to path should trigger a reload.
+func (o WatchOptions) matches(path string) bool {
+	base := filepath.Base(path)
+	include := o.IncludeGlobs
+	if include == nil {
+		include = defaultWatchGlobs
+	}
+	matchAny := func(globs []string) bool {
+		for _, g := range globs {
+			if ok, _ := filepath.Match(g, base); ok {
+				return true
+			}
+		}
+		return false
+	}
+	return matchAny(include) && !matchAny(o.ExcludeGlobs)
+}
+
+// Contents of WatchOptions.StatusFile
+type watchStatus struct {
+	Watching    bool      `json:"watching"`
+	LastReload  time.Time `json:"last_reload"`
+	PagesLoaded int       `json:"pages_loaded"`
+	Errors      int       `json:"errors"`
+}
+
+// Atomically replace the status file (write to .tmp then rename).
+func writeStatus(path string, status watchStatus) error {
+	b, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
 // WatchDir: watches directory and reloads wiki on changes.
-func WatchDir(ctx context.Context, wiki *Wiki) error {
+// Template and style changes only reload those, changed pages are
+// reloaded singly and anything else triggers a full reload. Hidden and
+// ignored directories aren't watched.
+// Changes are handled once there have been none for debounce, so a burst
+// of writes causes one reload. Zero handles every event immediately.
+// Non-fatal errors are sent to errCh; the returned error is fatal.
+func WatchDir(ctx context.Context, wiki *Wiki, opts WatchOptions, debounce time.Duration, errCh chan<- error) error {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return err
 	}
 	defer watcher.Close()
 
-	// add directory and subdirs (non-recursive for simplicity)
-	// NOTE: Won't work for subdirs
-	if err := watcher.Add(wiki.Dir); err != nil {
+	// add directory and subdirs, matching the recursive walk in loadPages
+	skipDir := func(name string) bool {
+		return strings.HasPrefix(name, ".") || wiki.isIgnored(name)
+	}
+	if err := addWatchDirs(watcher, wiki.Dir, skipDir); err != nil {
 		return err
 	}
 
-	debounce := time.NewTimer(0)
-	if !debounce.Stop() {
-		<-debounce.C
+	report := func(err error) {
+		select {
+		case errCh <- err:
+		case <-ctx.Done():
+		}
+	}
+
+	status := watchStatus{Watching: true}
+	writeStatusFile := func() {
+		if opts.StatusFile == "" {
+			return
+		}
+		status.LastReload = time.Now().UTC()
+		wiki.mu.RLock()
+		status.PagesLoaded = len(wiki.Pages)
+		wiki.mu.RUnlock()
+		if err := writeStatus(opts.StatusFile, status); err != nil {
+			report(fmt.Errorf("writing status file %s: %w", opts.StatusFile, err))
+		}
+	}
+	notify := func(name string) {
+		if opts.OnReload != nil {
+			opts.OnReload(name)
+		}
+	}
+	reload := func() {
+		if err := wiki.Update(); errors.Is(err, ErrReloadInProgress) {
+			slog.Debug("skipping reload", "reason", err)
+			return
+		} else if err != nil {
+			status.Errors++
+			report(fmt.Errorf("wiki update: %w", err))
+		}
+		writeStatusFile()
+		notify("*")
+	}
+
+	// Changes seen since the debounce timer was last reset
+	var (
+		fullReload     bool
+		templateReload bool
+		changedPages   = map[string]bool{}
+	)
+	flush := func() {
+		if templateReload {
+			if err := wiki.ReloadTemplate(); err != nil {
+				status.Errors++
+				report(fmt.Errorf("template reload: %w", err))
+			} else if !fullReload {
+				notify("*")
+			}
+		}
+		if fullReload {
+			reload()
+		} else if len(changedPages) > 0 {
+			for name := range changedPages {
+				if err := wiki.UpdateSingle(name); err != nil {
+					status.Errors++
+					report(fmt.Errorf("updating page %s: %w", name, err))
+				} else {
+					notify(name)
+				}
+			}
+			writeStatusFile()
+		}
+		fullReload, templateReload = false, false
+		clear(changedPages)
+	}
+
+	timer := time.NewTimer(0)
+	if !timer.Stop() {
+		<-timer.C
 	}
 	for {
 		select {
@@ -128,57 +502,297 @@ func WatchDir(ctx context.Context, wiki *Wiki) error {
 			if !ok {
 				return nil
 			}
-			// We debounce rapid events
-			_ = ev
-			debounce.Reset(200 * time.Millisecond)
-		case <-debounce.C:
-			if err := wiki.Update(); err != nil {
-				slog.Error("wiki update failure", "error", err)
+			wiki.Metrics.WatcherEventCount.Add(1)
+			// New subdirectories must be watched too. Files may have been
+			// created inside before the watch was registered, so reload now.
+			rel, _ := filepath.Rel(wiki.Dir, ev.Name)
+			rel = filepath.ToSlash(rel)
+			if slices.ContainsFunc(strings.Split(rel, "/"), wiki.isIgnored) {
 				continue
 			}
+			if ev.Has(fsnotify.Create) {
+				if fi, err := os.Stat(ev.Name); err == nil && fi.IsDir() {
+					if err := addWatchDirs(watcher, ev.Name, skipDir); err != nil {
+						report(fmt.Errorf("watching %s: %w", ev.Name, err))
+					}
+					reload()
+					continue
+				}
+			}
+
+			if !opts.matches(ev.Name) {
+				continue
+			}
+
+			inRoot := filepath.Dir(ev.Name) == filepath.Clean(wiki.Dir)
+			base := filepath.Base(ev.Name)
+			switch {
+			case strings.HasSuffix(base, ".bak"):
+				continue // backups written by WritePage
+			case inRoot && (base == "template.html" || base == "style.css"):
+				templateReload = true
+			case strings.HasSuffix(base, ".md") && isPageDir(path.Dir(rel)) && ev.Has(fsnotify.Write|fsnotify.Create):
+				changedPages[strings.TrimSuffix(rel, ".md")] = true
+			default:
+				fullReload = true
+			}
+			// We debounce rapid events
+			if debounce == 0 {
+				flush()
+			} else {
+				timer.Reset(debounce)
+			}
+		case <-timer.C:
+			flush()
 		case err, ok := <-watcher.Errors:
 			if !ok {
 				return nil
 			}
-			slog.Error("watcher failure", "error", err)
+			status.Errors++
+			wiki.Metrics.WatcherErrorCount.Add(1)
+			report(fmt.Errorf("watcher: %w", err))
+		}
+	}
+}
+
+// Add dir and every directory beneath it to the watcher, except those
+// whose base names skip reports.
+func addWatchDirs(watcher *fsnotify.Watcher, dir string, skip func(name string) bool) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && path != dir && skip(d.Name()) {
+			return filepath.SkipDir
+		} else if d.IsDir() {
+			return watcher.Add(path)
+		}
+		return nil
+	})
+}
+
+// Serve $WIKI/_static/favicon.ico or the embedded default.
+func faviconHandler(dir string) http.Handler {
+	p := filepath.Join(dir, "_static", "favicon.ico")
+	if _, err := os.Stat(p); err == nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.ServeFile(w, r, p)
+		})
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write([]byte(defaultFavicon))
+	})
+}
+
+// Report whether the wiki has pages to serve, for load balancers and
+// liveness probes.
+func (s *Server) serveHealth(w http.ResponseWriter, r *http.Request) {
+	// Built-in pages always exist so only those with files count
+	pages := 0
+	s.wiki.mu.RLock()
+	for _, p := range s.wiki.Pages {
+		if !p.ModTime.IsZero() {
+			pages++
 		}
 	}
+	s.wiki.mu.RUnlock()
+
+	if pages == 0 {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "degraded"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":         "ok",
+		"pages":          pages,
+		"uptime_seconds": int(time.Since(s.startedAt).Seconds()),
+	})
 }
 
-func Serve(dir string, port string, watch bool) error {
-	wiki, err := NewWiki(dir)
+// Serve files from $WIKI/_static/, refusing markdown, backups and dotfiles.
+// Content types are inferred from file extensions by http.FileServer.
+func staticHandler(dir string) http.Handler {
+	files := http.StripPrefix("/_static/", http.FileServer(http.Dir(filepath.Join(dir, "_static"))))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, part := range strings.Split(r.URL.Path, "/") {
+			if strings.HasPrefix(part, ".") || strings.HasSuffix(part, ".md") || strings.HasSuffix(part, ".bak") {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+// The default wiki title, the base name of dir.
+func dirTitle(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
 	if err != nil {
-		return err
+		return "", err
 	}
+	return filepath.Base(abs), nil
+}
 
-	if err := wiki.Update(); err != nil {
+// Serve the wiki in dir until ctx is cancelled, then shut down gracefully.
+func Serve(ctx context.Context, dir string, opts ServeOptions) error {
+	wiki, err := NewWiki(dir, opts.Wiki)
+	if err != nil {
 		return err
 	}
 
-	style, err := GetStyle(dir)
-	if err != nil {
+	if err := wiki.ForceUpdate(); err != nil {
 		return err
 	}
 
-	server := &Server{wiki: wiki}
+	if opts.Title == "" {
+		if opts.Title, err = dirTitle(dir); err != nil {
+			return err
+		}
+	}
+	server := &Server{
+		wiki:       wiki,
+		opts:       opts,
+		reload:     newReloadHub(),
+		startedAt:  time.Now(),
+		authorized: basicAuthChecker(opts.Auth),
+	}
 
 	r := http.NewServeMux()
 	r.Handle("/{$}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		http.Redirect(w, r, "/index", http.StatusSeeOther)
+		http.Redirect(w, r, server.url("/index"), http.StatusSeeOther)
 	}))
-	r.Handle("/{name}", server)
+	r.Handle("/{name...}", server)
+	r.HandleFunc("GET /history/{name...}", server.serveHistory)
+	r.HandleFunc("GET /tag/{tag}", server.serveTag)
+	r.Handle("/favicon.ico", faviconHandler(dir))
+	r.HandleFunc("GET /feed.xml", server.serveFeed)
+	r.HandleFunc("GET /sitemap.xml", server.serveSitemap)
+	r.HandleFunc("GET /healthz", server.serveHealth)
+	r.Handle("/_static/", staticHandler(dir))
 	r.Handle("/style.css", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wiki.mu.RLock()
+		style := wiki.Style
+		wiki.mu.RUnlock()
 		w.Header().Set("Content-Type", "text/css")
 		w.Write([]byte(style))
 	}))
-	r.Handle("/api/{op}/{name}", &Api{wiki: wiki})
+	api := NewApi(wiki, opts.NameValidator)
+	api.NamePattern = opts.NamePattern
+	api.basePath = opts.BasePath
+	api.authorized = server.authorized
+	api.authRealm = opts.AuthRealm
+	api.pages = server
+	r.HandleFunc("GET /new", api.serveNew)
+	r.Handle("/api/{op}/{name...}", api)
+	r.HandleFunc("POST /api/pages/bulk-update", api.serveBulkUpdate)
+	r.HandleFunc("POST /api/preview", api.servePreview)
+	r.HandleFunc("GET /api/v1/pages", api.serveV1List)
+	r.HandleFunc("POST /api/v1/pages", api.serveV1Create)
+	r.HandleFunc("GET /api/v1/pages/{name...}", api.serveV1Get)
+	r.HandleFunc("PUT /api/v1/pages/{name...}", api.serveV1Put)
+	r.HandleFunc("DELETE /api/v1/pages/{name...}", api.serveV1Delete)
+	r.HandleFunc("GET /api/metrics", api.serveMetrics)
+	r.HandleFunc("GET /api/pages", api.serveListPages)
+	r.HandleFunc("GET /api/graph", api.serveGraph)
+	r.HandleFunc("GET /api/graph/cycles", api.serveCycles)
+	r.HandleFunc("GET /api/search", api.serveSearch)
+	r.HandleFunc("GET /api/autocomplete", api.serveAutocomplete)
+	r.HandleFunc("GET /api/broken-links", api.serveBrokenLinks)
+	r.HandleFunc("GET /api/orphans", api.serveOrphans)
+	r.HandleFunc("GET /api/stats", api.serveStats)
+	r.Handle("GET /ws/reload", websocket.Handler(server.reload.serve))
 
-	if watch {
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
-		go WatchDir(ctx, wiki)
+	var handler http.Handler = r
+	if opts.Metrics {
+		// Before the watcher starts reloading, as this hooks into reloads
+		m := newPromMetrics(wiki)
+		r.Handle("GET /metrics", m.handler())
+		handler = m.middleware(r)
 	}
 
-	slog.Info("serving", "wiki", dir, "port", port)
-	return http.ListenAndServe(":"+port, r)
+	if opts.Watch {
+		errCh := make(chan error)
+		go func() {
+			for err := range errCh {
+				slog.Error("watch failure", "error", err)
+			}
+		}()
+		watchOpts := opts.WatchOptions
+		if watchOpts.OnReload == nil {
+			watchOpts.OnReload = server.reload.broadcast
+		}
+		go func() {
+			err := WatchDir(ctx, wiki, watchOpts, opts.Debounce, errCh)
+			// Nothing sends once WatchDir returns, so let the logger above finish
+			close(errCh)
+			if err != nil {
+				slog.Error("watcher stopped", "error", err)
+			}
+		}()
+	}
+
+	if len(opts.Auth) > 0 && !opts.AuthPrivateOnly {
+		handler = basicAuth(handler, opts.Auth, opts.AuthRealm)
+	}
+	if opts.BasePath != "" {
+		prefixed := http.NewServeMux()
+		prefixed.Handle(opts.BasePath+"/", http.StripPrefix(opts.BasePath, handler))
+		prefixed.Handle(opts.BasePath, http.RedirectHandler(opts.BasePath+"/", http.StatusMovedPermanently))
+		handler = prefixed
+	}
+
+	// Listening first means the address is known even for port 0
+	ln, err := net.Listen("tcp", ":"+opts.Port)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: handler}
+	// Shutdown doesn't wait for hijacked connections so close them here
+	srv.RegisterOnShutdown(server.reload.close)
+
+	serveErr := make(chan error, 2)
+	var redirect *http.Server // port 80, when using ACME
+	if opts.ACMEDomain != "" {
+		certs := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(opts.ACMEDomain),
+			Cache:      autocert.DirCache(filepath.Join(dir, acmeCacheDir)),
+		}
+		srv.TLSConfig = certs.TLSConfig()
+		// Answers the ACME http-01 challenge and sends everything else to HTTPS
+		redirect = &http.Server{Addr: ":80", Handler: certs.HTTPHandler(nil)}
+		go func() {
+			serveErr <- redirect.ListenAndServe()
+		}()
+	}
+
+	slog.Info("serving", "wiki", dir, "addr", ln.Addr().String(), "tls", srv.TLSConfig != nil || opts.TLSCert != "")
+	go func() {
+		if srv.TLSConfig != nil || opts.TLSCert != "" {
+			serveErr <- srv.ServeTLS(ln, opts.TLSCert, opts.TLSKey)
+		} else {
+			serveErr <- srv.Serve(ln)
+		}
+	}()
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down", "timeout", opts.ShutdownTimeout)
+	shutdownCtx := context.Background()
+	if opts.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, opts.ShutdownTimeout)
+		defer cancel()
+	}
+	if redirect != nil {
+		redirect.Shutdown(shutdownCtx)
+	}
+	return srv.Shutdown(shutdownCtx)
 }
+
+// Where ACME certificates are cached, inside the wiki directory. Hidden
+// so it's never loaded as pages or watched.
+const acmeCacheDir = ".autocert"
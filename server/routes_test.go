@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The page handler routed as Serve routes it.
+func newTestServer(wiki *Wiki) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/{name...}", &Server{wiki: wiki})
+	return mux
+}
+
+func get(h http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w
+}
+
+func TestNormalizedNamesServeSamePage(t *testing.T) {
+	wiki := newTestWiki(t, map[string]string{"my-page": "# My Page\n"})
+	wiki.Options.NormalizeSlugs = true
+	wiki.Options.NormalizeUnderscores = true
+	srv := newTestServer(wiki)
+
+	want := get(srv, "/my-page")
+	if want.Code != http.StatusOK {
+		t.Fatalf("/my-page: got %d, want %d", want.Code, http.StatusOK)
+	}
+	for _, path := range []string{"/my%20page", "/my_page"} {
+		w := get(srv, path)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: got %d, want %d", path, w.Code, http.StatusOK)
+		} else if w.Body.String() != want.Body.String() {
+			t.Errorf("%s: served a different page from /my-page", path)
+		}
+	}
+
+	wiki.Options.NormalizeUnderscores = false
+	if w := get(srv, "/my_page"); w.Code != http.StatusNotFound {
+		t.Errorf("/my_page without underscore normalization: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestWatchReloadsTemplate(t *testing.T) {
+	wiki := newTestWiki(t, map[string]string{"foo": "# Foo\n"})
+	srv := newTestServer(wiki)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 10)
+	done := make(chan error)
+	go func() { done <- WatchDir(ctx, wiki, WatchOptions{}, 0, errCh) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	// Give the watcher a moment to start
+	time.Sleep(100 * time.Millisecond)
+
+	tmpl := "<title>custom {{.Title}}</title>\n"
+	if err := os.WriteFile(filepath.Join(wiki.Dir, "template.html"), []byte(tmpl), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		body := get(srv, "/foo").Body.String()
+		if body == "<title>custom Foo</title>\n" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("new template not served, got %q", body)
+		}
+		select {
+		case err := <-errCh:
+			t.Fatal(err)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+func TestETagChangesWithFrontmatter(t *testing.T) {
+	wiki := newTestWiki(t, map[string]string{"foo": "---\nauthor: a\n---\n# Foo\n"})
+	before, _ := wiki.ReadPage("foo")
+
+	if err := wiki.WritePage("foo", "---\nauthor: b\n---\n# Foo\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := wiki.UpdateSingle("foo"); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := wiki.ReadPage("foo")
+	if before.HTML != after.HTML {
+		t.Fatalf("HTML changed, so this doesn't test frontmatter alone")
+	}
+	if before.ETag == after.ETag {
+		t.Errorf("ETag %q unchanged by a frontmatter edit", after.ETag)
+	}
+}
+
+func TestHealthNeedsPageFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		pages map[string]string
+		want  int
+	}{
+		{"empty", nil, http.StatusServiceUnavailable},
+		{"pages", map[string]string{"index": "# Home\n"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{wiki: newTestWiki(t, tt.pages)}
+			w := httptest.NewRecorder()
+			s.serveHealth(w, httptest.NewRequest("GET", "/healthz", nil))
+			if w.Code != tt.want {
+				t.Errorf("got %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestTagPageUsesWikiTemplateData(t *testing.T) {
+	wiki := newTestWiki(t, map[string]string{"foo": "---\ntags: [go]\n---\n# Foo\n"})
+	s := &Server{wiki: wiki, opts: ServeOptions{Title: "Notebook", BaseURL: "https://example.com/"}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /tag/{tag}", s.serveTag)
+
+	body := get(mux, "/tag/go").Body.String()
+	for _, want := range []string{"Notebook", `content="https://example.com/tag/go"`, `href="../foo"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("tag page is missing %s:\n%s", want, body)
+		}
+	}
+}
+
+func TestShortPagesShowReadingTime(t *testing.T) {
+	wiki := newTestWiki(t, map[string]string{"short": "# Short\n", "tagged": "---\ntags: [go]\n---\n# Tagged\n"})
+	s := &Server{wiki: wiki}
+	mux := http.NewServeMux()
+	mux.Handle("/{name...}", s)
+	mux.HandleFunc("GET /tag/{tag}", s.serveTag)
+
+	if body := get(mux, "/short").Body.String(); !strings.Contains(body, "&lt; 1 min") {
+		t.Errorf("page without an excerpt has no reading time:\n%s", body)
+	}
+	if body := get(mux, "/tag/go").Body.String(); strings.Contains(body, "reading-time") {
+		t.Errorf("tag page has a reading time:\n%s", body)
+	}
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"cmp"
+	"html/template"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// A page matching a search query.
+type SearchResult struct {
+	Name    string  `json:"name"`
+	Title   string  `json:"title"`
+	Snippet string  `json:"snippet"` // text around the first match
+	Score   float64 `json:"score"`
+}
+
+// Inverted index: word → page name → number of occurrences
+type searchIndex map[string]map[string]int
+
+// Common words not worth indexing
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "but": true, "by": true, "for": true, "from": true, "if": true,
+	"in": true, "into": true, "is": true, "it": true, "no": true, "not": true,
+	"of": true, "on": true, "or": true, "so": true, "that": true, "the": true,
+	"their": true, "then": true, "there": true, "these": true, "they": true,
+	"this": true, "to": true, "was": true, "will": true, "with": true,
+}
+
+// Lowercase words in s with punctuation and stop words removed.
+func tokenize(s string) []string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return slices.DeleteFunc(words, func(w string) bool { return stopWords[w] })
+}
+
+func buildSearchIndex(pages map[string]*Page) searchIndex {
+	idx := searchIndex{}
+	for _, p := range pages {
+		idx.add(p)
+	}
+	return idx
+}
+
+// Index a page's words, unless it's a draft that shouldn't be found.
+func (idx searchIndex) add(p *Page) {
+	if p.hidden {
+		return
+	}
+	for _, word := range tokenize(searchText(p)) {
+		if idx[word] == nil {
+			idx[word] = map[string]int{}
+		}
+		idx[word][p.Name]++
+	}
+}
+
+// A page's text without its frontmatter, which isn't searched.
+func searchText(p *Page) string {
+	_, body, _ := splitFrontmatter(p.Raw)
+	return body
+}
+
+func (idx searchIndex) remove(name string) {
+	for word, names := range idx {
+		delete(names, name)
+		if len(names) == 0 {
+			delete(idx, word)
+		}
+	}
+}
+
+// At most this many suggestions are returned by Autocomplete
+const maxSuggestions = 20
+
+// A page suggested while typing a wikilink.
+type Suggestion struct {
+	Name  string `json:"name"`
+	Title string `json:"title"`
+}
+
+// Pages whose name or title contains q, ignoring case. Those starting
+// with q come first, then each group is sorted by name. Drafts and
+// private pages aren't suggested.
+func (w *Wiki) Autocomplete(q string) []Suggestion {
+	lower := strings.ToLower(q)
+	hasPrefix := func(s string) bool {
+		return len(s) >= len(q) && strings.EqualFold(s[:len(q)], q)
+	}
+	// Only the first few of each group by name are kept, which is much
+	// cheaper than sorting every match in a large wiki.
+	keep := func(group []Suggestion, s Suggestion) []Suggestion {
+		i, _ := slices.BinarySearchFunc(group, s.Name, func(g Suggestion, name string) int {
+			return strings.Compare(g.Name, name)
+		})
+		if i == maxSuggestions {
+			return group
+		}
+		group = slices.Insert(group, i, s)
+		return group[:min(len(group), maxSuggestions)]
+	}
+
+	var prefixed, contained []Suggestion
+	w.mu.RLock()
+	for name, p := range w.Pages {
+		if p.hidden || !p.IsPublic() {
+			continue
+		}
+		s := Suggestion{Name: name, Title: p.Title}
+		if hasPrefix(name) || hasPrefix(p.Title) {
+			prefixed = keep(prefixed, s)
+		} else if strings.Contains(strings.ToLower(name), lower) || strings.Contains(strings.ToLower(p.Title), lower) {
+			contained = keep(contained, s)
+		}
+	}
+	w.mu.RUnlock()
+
+	suggestions := append(append([]Suggestion{}, prefixed...), contained...)
+	return suggestions[:min(len(suggestions), maxSuggestions)]
+}
+
+// Pages containing every word of the query, best matches first.
+// Equal scores are ordered by page name so results are deterministic.
+// Private pages are only found if authorized.
+func (w *Wiki) Search(query string, authorized bool) []SearchResult {
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return []SearchResult{}
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	scores := map[string]float64{}
+	for name, n := range w.index[terms[0]] {
+		scores[name] = float64(n)
+	}
+	for _, term := range terms[1:] {
+		for name := range scores {
+			n, ok := w.index[term][name]
+			if !ok {
+				delete(scores, name)
+				continue
+			}
+			scores[name] += float64(n)
+		}
+	}
+
+	results := []SearchResult{}
+	for name, score := range scores {
+		p := w.Pages[name]
+		if !w.visible(p, authorized) {
+			continue
+		}
+		results = append(results, SearchResult{
+			Name:    name,
+			Title:   p.Title,
+			Snippet: snippet(searchText(p), terms[0]),
+			Score:   score,
+		})
+	}
+	slices.SortFunc(results, func(a, b SearchResult) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return results
+}
+
+// Characters of context either side of a match in a snippet
+const snippetRadius = 60
+
+// Plain text around the first occurrence of term in raw.
+func snippet(raw string, term string) string {
+	r := []rune(raw)
+	lower := []rune(strings.ToLower(raw))
+	t := []rune(term)
+
+	at := 0
+	for i := 0; i+len(t) <= len(lower); i++ {
+		if slices.Equal(lower[i:i+len(t)], t) {
+			at = i
+			break
+		}
+	}
+
+	start, end := max(0, at-snippetRadius), min(len(r), at+len(t)+snippetRadius)
+	s := strings.Join(strings.Fields(string(r[start:end])), " ")
+	if start > 0 {
+		s = "…" + s
+	}
+	if end < len(r) {
+		s += "…"
+	}
+	return s
+}
+
+var searchTmpl = template.Must(template.New("search").Parse(`
+<form action="search" method="get">
+  <input type="search" name="q" value="{{.Query}}" placeholder="search..." autofocus>
+</form>
+{{if .Query}}
+<ul class="search-results">
+  {{range .Results}}
+  <li><a href="{{.Name}}">{{or .Title .Name}}</a><br><small>{{.Snippet}}</small></li>
+  {{else}}
+  <li>no results</li>
+  {{end}}
+</ul>
+{{end}}`))
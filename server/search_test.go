@@ -0,0 +1,26 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSearchIgnoresFrontmatter(t *testing.T) {
+	wiki := newTestWiki(t, map[string]string{
+		"tagged":    "---\ntags: [gardening]\n---\n# Roses\nPrune in spring.\n",
+		"mentioned": "# Notes\nSome gardening tips.\n",
+	})
+
+	results := wiki.Search("gardening", false)
+	if len(results) != 1 || results[0].Name != "mentioned" {
+		t.Fatalf("got %+v, want only the page mentioning gardening", results)
+	}
+
+	results = wiki.Search("spring", false)
+	if len(results) != 1 {
+		t.Fatalf("got %+v, want the roses page", results)
+	}
+	if strings.Contains(results[0].Snippet, "tags") {
+		t.Errorf("snippet %q includes frontmatter", results[0].Snippet)
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/xml"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A sitemap, see https://www.sitemaps.org/protocol.html
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc        string `xml:"loc"`
+	LastMod    string `xml:"lastmod,omitempty"`
+	ChangeFreq string `xml:"changefreq"`
+}
+
+// List every public page for search engines. Drafts (names starting
+// with _), built-in pages and redirects are left out.
+func (s *Server) serveSitemap(w http.ResponseWriter, r *http.Request) {
+	if s.opts.BaseURL == "" {
+		http.Error(w, "sitemap needs -base-url", http.StatusNotFound)
+		return
+	}
+	base := strings.TrimSuffix(s.opts.BaseURL, "/") + "/"
+
+	var set sitemapURLSet
+	s.wiki.mu.RLock()
+	for name, p := range s.wiki.Pages {
+		if _, ok := builtinPages[name]; ok || strings.HasPrefix(name, "_") {
+			continue
+		}
+		if p.Alias != "" || p.RedirectTo != "" || p.hidden || !p.IsPublic() {
+			continue
+		}
+		u := sitemapURL{Loc: base + escapeName(name), ChangeFreq: "weekly"}
+		if !p.ModTime.IsZero() {
+			u.LastMod = p.ModTime.UTC().Format(time.RFC3339)
+		}
+		set.URLs = append(set.URLs, u)
+	}
+	s.wiki.mu.RUnlock()
+	slices.SortFunc(set.URLs, func(a, b sitemapURL) int {
+		return strings.Compare(a.Loc, b.Loc)
+	})
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(set); err != nil {
+		slog.Error("sitemap encode", "error", err)
+	}
+}
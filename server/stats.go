@@ -0,0 +1,66 @@
+package server
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// A summary of the shape of the wiki.
+type WikiStats struct {
+	TotalPages       int     `json:"total_pages"`
+	TotalWords       int     `json:"total_words"`
+	TotalLinks       int     `json:"total_links"`
+	TotalBacklinks   int     `json:"total_backlinks"`
+	AverageWordCount float64 `json:"average_word_count"`
+	MostLinkedPage   string  `json:"most_linked_page"` // most backlinks, empty if nothing is linked
+}
+
+// Count the wiki's pages, words and links. Built-in, alias, redirect,
+// draft and private pages aren't counted.
+func (w *Wiki) Stats() WikiStats {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	var stats WikiStats
+	most := 0
+	for name, p := range w.Pages {
+		if _, ok := builtinPages[name]; ok {
+			continue
+		}
+		if p.Alias != "" || p.RedirectTo != "" || p.hidden || !p.IsPublic() {
+			continue
+		}
+		stats.TotalPages++
+		stats.TotalWords += p.WordCount
+		stats.TotalLinks += len(p.Links)
+		stats.TotalBacklinks += len(p.Backlinks)
+		// Ties go to the first name alphabetically so the answer is stable.
+		if n := len(p.Backlinks); n > most || (n == most && n > 0 && name < stats.MostLinkedPage) {
+			most, stats.MostLinkedPage = n, name
+		}
+	}
+	if stats.TotalPages > 0 {
+		stats.AverageWordCount = float64(stats.TotalWords) / float64(stats.TotalPages)
+	}
+	return stats
+}
+
+// The wiki's statistics as JSON.
+func (a *Api) serveStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.wiki.Stats())
+}
+
+var statsTmpl = template.Must(template.New("stats").Parse(`
+<table class="stats">
+  <tr><th>Pages</th><td>{{.TotalPages}}</td></tr>
+  <tr><th>Words</th><td>{{.TotalWords}}</td></tr>
+  <tr><th>Average words per page</th><td>{{printf "%.0f" .AverageWordCount}}</td></tr>
+  <tr><th>Links</th><td>{{.TotalLinks}}</td></tr>
+  <tr><th>Backlinks</th><td>{{.TotalBacklinks}}</td></tr>
+  <tr><th>Most linked page</th><td>{{with .MostLinkedPage}}<a href="{{.}}">{{.}}</a>{{else}}none{{end}}</td></tr>
+</table>`))
+
+// A summary of the wiki's size and linking
+func (s *Server) statsContent(r *http.Request) (template.HTML, error) {
+	return renderFragment(statsTmpl, s.wiki.Stats())
+}
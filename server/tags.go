@@ -0,0 +1,166 @@
+package server
+
+import (
+	"cmp"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A list of strings from a frontmatter value, either a list or a single
+// comma separated string.
+func metaStrings(v any) []string {
+	var items []string
+	switch v := v.(type) {
+	case string:
+		items = strings.Split(v, ",")
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				items = append(items, s)
+			}
+		}
+	}
+
+	var out []string
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item != "" && !slices.Contains(out, item) {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// Pages carrying each tag, sorted by name. Drafts aren't listed.
+func buildTags(pages map[string]*Page) map[string][]*Page {
+	tags := map[string][]*Page{}
+	for _, p := range pages {
+		if p.hidden {
+			continue
+		}
+		for _, tag := range p.Tags {
+			tags[tag] = append(tags[tag], p)
+		}
+	}
+	for _, tagged := range tags {
+		slices.SortFunc(tagged, func(a, b *Page) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+	}
+	return tags
+}
+
+// A tag and how many pages carry it.
+type TagCount struct {
+	Tag   string
+	Count int
+}
+
+// Every tag in the wiki, sorted by name. Private pages and the tags only
+// they carry aren't counted unless authorized.
+func (w *Wiki) TagCounts(authorized bool) []TagCount {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	counts := make([]TagCount, 0, len(w.Tags))
+	for tag, pages := range w.Tags {
+		n := 0
+		for _, p := range pages {
+			if w.visible(p, authorized) {
+				n++
+			}
+		}
+		if n > 0 {
+			counts = append(counts, TagCount{Tag: tag, Count: n})
+		}
+	}
+	slices.SortFunc(counts, func(a, b TagCount) int {
+		return cmp.Compare(a.Tag, b.Tag)
+	})
+	return counts
+}
+
+// Copies of the pages carrying a tag, sorted by name. Private pages are
+// only included if authorized.
+func (w *Wiki) TaggedPages(tag string, authorized bool) []*Page {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	var pages []*Page
+	for _, p := range w.Tags[tag] {
+		if !w.visible(p, authorized) {
+			continue
+		}
+		page := *p
+		pages = append(pages, &page)
+	}
+	return pages
+}
+
+var tagsTmpl = template.Must(template.New("tags").Parse(`
+<p class="tag-cloud">
+  {{range .}}
+  <a href="tag/{{.Tag}}" style="font-size: {{.Size}}em">{{.Tag}}</a>
+  {{else}}
+  no tags
+  {{end}}
+</p>`))
+
+// A cloud of every tag, sized by how many pages carry it
+func (s *Server) tagsContent(r *http.Request) (template.HTML, error) {
+	counts := s.wiki.TagCounts(s.canSeePrivate(r))
+	most := 1
+	for _, c := range counts {
+		most = max(most, c.Count)
+	}
+
+	type sizedTag struct {
+		TagCount
+		Size string
+	}
+	tags := make([]sizedTag, len(counts))
+	for i, c := range counts {
+		// 1em for the rarest up to 2em for the most common
+		size := 1 + float64(c.Count-1)/float64(max(most-1, 1))
+		tags[i] = sizedTag{c, strconv.FormatFloat(size, 'f', 2, 64)}
+	}
+	return renderFragment(tagsTmpl, tags)
+}
+
+var tagTmpl = template.Must(template.New("tag").Parse(`
+<h1>Tagged {{.Tag}}</h1>
+<ul class="tagged">
+  {{range .Pages}}
+  <li><a href="../{{.Name}}">{{or .Title .Name}}</a></li>
+  {{else}}
+  <li>no pages</li>
+  {{end}}
+</ul>
+<p><a href="../tags">all tags</a></p>`))
+
+// The pages carrying a tag, rendered with the wiki template.
+func (s *Server) serveTag(w http.ResponseWriter, r *http.Request) {
+	tag := r.PathValue("tag")
+	content, err := renderFragment(tagTmpl, map[string]any{
+		"Tag":   tag,
+		"Pages": s.wiki.TaggedPages(tag, s.canSeePrivate(r)),
+	})
+	if err != nil {
+		slog.Error("tag page", "tag", tag, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	s.wiki.mu.RLock()
+	tmpl := s.wiki.Template
+	s.wiki.mu.RUnlock()
+	data := s.pageData(Page{Name: "tags", Title: "Tagged " + tag}, content)
+	data["URL"] = s.baseURL(r) + "tag/" + url.PathEscape(tag)
+	if err := tmpl.Execute(w, data); err != nil {
+		slog.Error("page template execute", "error", err)
+	}
+}
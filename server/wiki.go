@@ -1,45 +1,113 @@
 package server
 
 import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"html/template"
 	"io/fs"
+	"log/slog"
+	"maps"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 	"unicode"
 
-	attributes "github.com/mdigger/goldmark-attributes"
-	fences "github.com/stefanfritsch/goldmark-fences"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/styles"
 	"github.com/yuin/goldmark"
-	"github.com/yuin/goldmark/extension"
-	"github.com/yuin/goldmark/parser"
-	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
 )
 
-// Markdown parser: GFM + ::: fences + {.foo} attrs
-// NOTE: In future add https://github.com/yuin/goldmark-highlighting
-var md = goldmark.New(
-	goldmark.WithExtensions(extension.GFM, &fences.Extender{}),
-	goldmark.WithParserOptions(parser.WithAttribute()),
-	goldmark.WithRendererOptions(html.WithUnsafe()),
-	attributes.Enable,
-)
+// Stylesheet for highlighted code blocks in the named chroma style.
+func highlightCSS(style string) (string, error) {
+	if style == "" {
+		return "", nil
+	}
+	var sb strings.Builder
+	formatter := chromahtml.New(chromahtml.WithClasses(true))
+	if err := formatter.WriteCSS(&sb, styles.Get(style)); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
 
 // A parsed wiki page.
 // Used to serve HTML and understand inter-page linking.
 type Page struct {
 	// Filled during dir-walk
-	Name string // filename relative to wiki dir without .md
+	Name string // path relative to wiki dir without .md, e.g. notes/foo
 	Raw  string // raw markdown
 	// Filled after parsing
-	Title     string          // from the first '#' heading else Name
-	HTML      template.HTML   // The converted markdown
-	Links     map[string]bool // set of outbound wiki-linked page names
-	Backlinks []string        // inbound wiki-linked page names
+	Title          string                 // from the first '#' heading else Name
+	HTML           template.HTML          // The converted markdown, embeds expanded and broken links marked
+	Links          map[string]bool        // set of outbound wiki-linked page names
+	Backlinks      []string               // inbound wiki-linked page names
+	BacklinkCounts map[string]int         // wikilinks to this page from each of Backlinks
+	ExternalLinks  []string               // http(s) link destinations
+	RedirectTo     string                 // from <!-- candl: redirect: target -->
+	Alias          string                 // canonical page if this name is only an alias of it
+	Aliases        []string               // from the aliases frontmatter field
+	Excerpt        string                 // short plain introduction, see extractIntro
+	Description    string                 // first paragraph as plain text, for link previews
+	ETag           string                 // hash of HTML and Backlinks, see buildBacklinks
+	ReadingTime    int                    // minutes to read at 200 words a minute, 0 if under one
+	WordCount      int                    // words of text, excluding markup
+	Checksum       string                 // hex SHA-256 of Raw, for change detection
+	Meta           map[string]interface{} // from ---yaml--- or +++toml+++ frontmatter
+	ModTime        time.Time              // file modification time, zero for built-in pages
+	TOC            []TOCEntry             // h1-h4 headings, nil if too few for a contents
+	Tags           []string               // from the tags frontmatter field
+	Draft          bool                   // from draft: true frontmatter or a file name starting with _
+
+	rendered  template.HTML  // HTML before expanding embeds and marking broken links
+	linkCount map[string]int // how many times each of Links is linked
+	hidden    bool           // a draft without Options.IncludeDrafts, left out of listings
+}
+
+// Whether anyone may read the page, rather than only those logged in
+// with -auth. Pages set visibility: private in their frontmatter.
+func (p *Page) IsPublic() bool {
+	return p.Meta["visibility"] != "private"
+}
+
+// Whether a reader may see p, authorized meaning they have -auth
+// credentials. Everything listing or showing pages checks this.
+func (w *Wiki) visible(p *Page, authorized bool) bool {
+	return authorized || p.IsPublic()
+}
+
+// The names of pages a reader may see, keeping names with no page.
+// w.mu must be held.
+func (w *Wiki) visibleNames(names []string, authorized bool) []string {
+	if authorized {
+		return names
+	}
+	var out []string
+	for _, name := range names {
+		if p, ok := w.Pages[name]; !ok || w.visible(p, authorized) {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// A heading in a page's table of contents.
+type TOCEntry struct {
+	Level int    // 1-4
+	ID    string // anchor, generated unless given as {#id}
+	Text  string
 }
 
 // A collection of parsed markdown pages.
@@ -47,16 +115,99 @@ type Wiki struct {
 	mu       sync.RWMutex // Used for safe reloads
 	Pages    map[string]*Page
 	Template *template.Template
-	Dir      string // The only required input
+	loaded   time.Time // when Template was last loaded, part of each ETag
+	Style    string    // served as /style.css
+	Dir      string    // The only required input
+	FS       fs.FS     // Dir, which pages are read from
+	Options  Options
+	Metrics  Metrics
+
+	md goldmark.Markdown // configured from Options
+
+	Tags      map[string][]*Page  // pages carrying each frontmatter tag
+	index     searchIndex         // words in Pages, see Search
+	EditLocks map[string]EditLock // keyed by page name
+	editMu    sync.Mutex          // guards EditLocks
+
+	reloadSem     chan struct{}       // held during a full reload
+	observeReload func(time.Duration) // if set, told how long each reload took
+}
+
+// Counters describing wiki activity, safe for concurrent use.
+type Metrics struct {
+	WatcherEventCount atomic.Int64 // filesystem events seen by WatchDir
+	WatcherErrorCount atomic.Int64 // errors reported by the watcher
+	ReloadCount       atomic.Int64 // successful full reloads by Update
+}
+
+// Settings controlling how a wiki is loaded.
+type Options struct {
+	ImplicitSearchLinks  bool          // every page is a backlink of /search
+	LinkCheckConcurrency int           // max parallel requests in CheckExternalLinks
+	NormalizeSlugs       bool          // treat spaces in names as hyphens
+	NormalizeUnderscores bool          // also treat underscores as hyphens
+	NoBackup             bool          // don't keep {name}.md.bak when writing pages
+	NoPreserveMtime      bool          // let RenamePage update mtimes of rewritten backlinkers
+	NoLazyImages         bool          // don't add loading="lazy" to images
+	NoSanitize           bool          // keep scripts and event handlers in a page's raw HTML
+	EditLockTimeout      time.Duration // how long an unused edit lock lasts, zero disables
+	BacklinkSort         BacklinkSortOrder
+	HighlightStyle       string   // chroma style for code blocks, empty disables
+	TOCMinHeadings       int      // pages with fewer headings get no table of contents
+	Git                  bool     // commit pages as they are written, renamed or deleted
+	EmbedDepth           int      // how deeply {{embed:name}} directives nest, default 3
+	Ignore               []string // filepath.Match globs of file and directory base names to skip
+	IncludeDrafts        bool     // list draft pages like any other
+	Extensions           []string // markdown extensions by name, nil uses DefaultExtensions
 }
 
-// regex for wikilinks like [[some-page]] or [[some-page|My Label]]
-// will return a list: "[[some-page]]", "some-page", ""
-// or                  "[[some-page]]", "some-page", "My Label"
-var linkRe = regexp.MustCompile(`\[\[([^\]|]+)(?:\|([^\]]+))?\]\]`)
+// How backlink lists are ordered.
+type BacklinkSortOrder int
+
+const (
+	SortDefault BacklinkSortOrder = iota // see sortBacklinks
+	SortAlpha                            // plain alphabetical
+)
+
+// regex for wikilinks like [[some-page]], [[some-page#section]] or [[some-page|My Label]]
+// will return a list: "[[some-page]]", "some-page", "", ""
+// or                  "[[some-page#section]]", "some-page", "#section", ""
+// or                  "[[some-page|My Label]]", "some-page", "", "My Label"
+var linkRe = regexp.MustCompile(`\[\[([^\]|#]*)(#[^\]|]*)?(?:\|([^\]]+))?\]\]`)
+
+var spaceRe = regexp.MustCompile(`\s+`)
+
+// Trim a wikilink target and collapse runs of internal whitespace.
+func cleanTarget(target string) string {
+	return spaceRe.ReplaceAllString(strings.TrimSpace(target), " ")
+}
+
+// Apply slug normalization (if enabled) so that "my page" and "my-page" match.
+func (w *Wiki) normalizeName(name string) string {
+	if w.Options.NormalizeSlugs {
+		name = strings.ReplaceAll(name, " ", "-")
+		if w.Options.NormalizeUnderscores {
+			name = strings.ReplaceAll(name, "_", "-")
+		}
+	}
+	return name
+}
+
+// regex for a redirect directive like <!-- candl: redirect: some-page -->
+var redirectRe = regexp.MustCompile(`<!--\s*candl:\s*redirect:\s*(\S+)\s*-->`)
 
 func (w *Wiki) getPagePath(name string) string {
-	return filepath.Join(w.Dir, name+".md")
+	return filepath.Join(w.Dir, filepath.FromSlash(name)+".md")
+}
+
+// The relative path from page name's directory up to the wiki root.
+func rootPrefix(name string) string {
+	return strings.Repeat("../", strings.Count(name, "/"))
+}
+
+// Like getPagePath but relative to w.FS
+func getPageFile(name string) string {
+	return name + ".md"
 }
 
 func sortBacklinks(a, b string) int {
@@ -72,60 +223,84 @@ func sortBacklinks(a, b string) int {
 	}
 	// Both are alphabetic - normal sort
 	if !aBeginsNum && !bBeginsNum {
-		if a < b {
-			return -1
-		}
-		if a > b {
-			return 1
-		}
-		return 0
+		return strings.Compare(a, b)
 	}
 
-	// Both are numeric - reverse sort (highest to lowest)
-	if aBeginsNum && bBeginsNum {
-		if a < b {
-			return 1
-		}
-		if a > b {
-			return -1
-		}
-		return 0
+	// Both are numeric - reverse sort (highest to lowest) by the leading
+	// number, so 10 comes before 9, then by the rest, e.g. for dates
+	an, aErr := strconv.Atoi(leadingDigits(a))
+	bn, bErr := strconv.Atoi(leadingDigits(b))
+	if aErr == nil && bErr == nil && an != bn {
+		return cmp.Compare(bn, an)
 	}
+	return strings.Compare(b, a)
+}
 
-	return 0 // Should never reach here
+// The digits s starts with, e.g. "2024" for "2024-01-05".
+func leadingDigits(s string) string {
+	i := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) })
+	if i < 0 {
+		return s
+	}
+	return s[:i]
 }
 
-func renameWikilinks(content []byte, oldName string, newName string) []byte {
+// Rewrite every wikilink in content using fn, which receives the cleaned
+// target and the label (empty if none). Links fn reports as unchanged are
+// left exactly as written.
+func transformWikilinks(content []byte, fn func(target, label string) (newTarget, newLabel string, changed bool)) []byte {
 	return linkRe.ReplaceAllFunc(content, func(m []byte) []byte {
 		sub := linkRe.FindStringSubmatch(string(m))
-		target := strings.TrimSpace(sub[1])
+		target, label, changed := fn(cleanTarget(sub[1]), sub[3])
 
-		if target != oldName {
+		section := sub[2] // kept as is, e.g. "#usage"
+		if !changed {
 			return m
-		} else if sub[2] != "" { // There _was_ a label
-			return []byte(fmt.Sprintf("[[%s|%s]]", newName, sub[2]))
+		} else if label != "" { // There _is_ a label
+			return []byte(fmt.Sprintf("[[%s%s|%s]]", target, section, label))
 		} else {
-			return []byte(fmt.Sprintf("[[%s]]", newName))
+			return []byte(fmt.Sprintf("[[%s%s]]", target, section))
 		}
 	})
 }
 
+func renameWikilinks(content []byte, oldName string, newName string) []byte {
+	return transformWikilinks(content, func(target, label string) (string, string, bool) {
+		return newName, label, target == oldName
+	})
+}
+
 // Update page objects resetting backlinks.
-func buildBacklinks(pages map[string]*Page) {
-	pageLinkers := map[string]map[string]struct{}{}
+func (w *Wiki) buildBacklinks(pages map[string]*Page) {
+	buildAliases(pages)
+
+	pageLinkers := map[string]map[string]int{}
 	for name := range pages {
-		pageLinkers[name] = map[string]struct{}{}
+		pageLinkers[name] = map[string]int{}
 	}
 
-	// Build set of pages each with set of pages that link to it
+	// Build set of pages each with how often each linker links to it
 	for linker, p := range pages {
+		if p.Alias != "" {
+			continue // never links anywhere
+		}
+		if p.hidden {
+			continue // drafts can be read but aren't linked from yet
+		}
 		for target := range p.Links {
-			if _, ok := pages[target]; ok {
-				pageLinkers[target][linker] = struct{}{}
+			if t, ok := pages[target]; ok {
+				n := p.linkCount[target]
+				// A link to an alias is a link to its canonical page
+				if t.Alias != "" {
+					target = t.Alias
+				}
+				pageLinkers[target][linker] += n
 			}
 		}
-		// Every page implicitly links to 'search'
-		pageLinkers["search"][linker] = struct{}{}
+		// Every page implicitly links to 'search', without mentioning it
+		if _, ok := pageLinkers["search"][linker]; w.Options.ImplicitSearchLinks && !ok {
+			pageLinkers["search"][linker] = 0
+		}
 	}
 
 	// Construct backlinks array for each page
@@ -135,91 +310,395 @@ func buildBacklinks(pages map[string]*Page) {
 			backlinks = append(backlinks, linker)
 		}
 		pages[name].Backlinks = backlinks
-		slices.SortFunc(pages[name].Backlinks, sortBacklinks)
+		pages[name].BacklinkCounts = linkers
+		// 'search' is linked from everywhere so sorting it is wasted effort
+		if name == "search" {
+			continue
+		}
+		if w.Options.BacklinkSort == SortAlpha {
+			slices.Sort(pages[name].Backlinks)
+		} else {
+			slices.SortFunc(pages[name].Backlinks, sortBacklinks)
+		}
+	}
+
+	// Which links are broken may have changed too
+	for _, p := range pages {
+		var broken []string
+		for target := range p.Links {
+			if _, ok := pages[target]; !ok {
+				broken = append(broken, target)
+			}
+		}
+		// Embeds need every page loaded so can only be expanded now
+		p.HTML = w.expandEmbeds(pages, p, !p.IsPublic())
+		if len(broken) > 0 {
+			p.HTML = markBrokenLinks(p.HTML, broken)
+		}
+		// Names are relative to the wiki root, not the page's directory
+		if prefix := rootPrefix(p.Name); prefix != "" {
+			p.HTML = rebaseLinks(p.HTML, prefix)
+		}
+		p.ETag = pageETag(p)
 	}
 }
 
-// Only call for files ending in .md
-func loadPage(path string) (*Page, error) {
-	// NOTE: We are assuming the file is at the root of the wiki
-	name := strings.TrimSuffix(filepath.Base(path), ".md")
+// A hash of everything from a page that ends up in the served HTML.
+// The checksum covers the frontmatter, which the template may show.
+func pageETag(p *Page) string {
+	h := fnv.New64a()
+	h.Write([]byte(p.Checksum))
+	h.Write([]byte(p.HTML))
+	for _, b := range p.Backlinks {
+		h.Write([]byte{0})
+		h.Write([]byte(b))
+		h.Write([]byte(strconv.Itoa(p.BacklinkCounts[b])))
+	}
+	return strconv.FormatUint(h.Sum64(), 36)
+}
 
-	b, err := os.ReadFile(path)
+// Replace alias pages with ones for the aliases pages currently declare.
+// Names of real pages are never taken over by an alias.
+func buildAliases(pages map[string]*Page) {
+	for name, p := range pages {
+		if p.Alias != "" {
+			delete(pages, name)
+		}
+	}
+	for name, p := range pages {
+		for _, alias := range p.Aliases {
+			if _, ok := pages[alias]; !ok {
+				pages[alias] = &Page{Name: alias, Alias: name}
+			}
+		}
+	}
+}
+
+// Pages that neither link to nor are linked from any other page, sorted
+// by name. Built-in pages like search and drafts don't count as orphans,
+// nor do private pages unless authorized.
+func (w *Wiki) OrphanedPages(authorized bool) []*Page {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	var orphans []*Page
+	for name, p := range w.Pages {
+		if _, ok := builtinPages[name]; ok {
+			continue
+		}
+		if p.Alias != "" || p.RedirectTo != "" || p.hidden || !w.visible(p, authorized) {
+			continue // just a way to reach another page, unfinished or private
+		}
+		if len(p.Links) == 0 && len(p.Backlinks) == 0 {
+			page := *p
+			orphans = append(orphans, &page)
+		}
+	}
+	slices.SortFunc(orphans, func(a, b *Page) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return orphans
+}
+
+// Max length in characters of Page.Excerpt
+const excerptLength = 300
+
+// The introduction of a page: everything before the first '---' line,
+// or the first paragraph if there is no such break. Excludes the title.
+func extractIntro(raw string) string {
+	lines := strings.Split(raw, "\n")
+	if len(lines) > 0 && strings.HasPrefix(lines[0], "# ") {
+		lines = lines[1:]
+	}
+
+	var intro []string
+	found := false
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "---" {
+			intro, found = lines[:i], true
+			break
+		}
+	}
+	if !found {
+		// Fall back to the first paragraph that isn't a heading
+		for _, line := range lines {
+			line = strings.TrimSpace(line)
+			if line == "" && len(intro) > 0 {
+				break
+			}
+			if line != "" && !strings.HasPrefix(line, "#") {
+				intro = append(intro, line)
+			}
+		}
+	}
+
+	s := strings.TrimSpace(strings.Join(intro, "\n"))
+	if r := []rune(s); len(r) > excerptLength {
+		s = string(r[:excerptLength])
+	}
+	return s
+}
+
+// Byte order mark some editors put at the start of UTF-8 files
+const utf8BOM = "\uFEFF"
+
+// Only call for files ending in .md, path is relative to w.FS. Unless
+// force, the loaded page is reused if the file's content is unchanged.
+func (w *Wiki) loadPage(file string, force bool) (*Page, error) {
+	name := strings.TrimSuffix(file, ".md")
+
+	b, err := fs.ReadFile(w.FS, file)
 	if err != nil {
 		return nil, err
 	}
 
+	info, err := fs.Stat(w.FS, file)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := strings.TrimPrefix(string(b), utf8BOM)
+	sum := sha256.Sum256([]byte(raw))
+	checksum := hex.EncodeToString(sum[:])
+	// Unchanged since the last load so there's no need to parse it again.
+	// Callers hold the write lock so reading w.Pages is safe.
+	if old, ok := w.Pages[name]; ok && !force && old.Name == name && old.Checksum == checksum {
+		old.ModTime = info.ModTime()
+		return old, nil
+	}
+
+	p, err := w.parsePage(name, raw)
+	if err != nil {
+		return nil, err
+	}
+	p.Checksum = checksum
+	p.ModTime = info.ModTime()
+	return p, nil
+}
+
+// Build a page from its markdown without touching the wiki's pages.
+// Embeds, broken links and backlinks need the other pages so are left
+// to buildBacklinks.
+func (w *Wiki) parsePage(name, raw string) (*Page, error) {
 	p := &Page{
-		Name:  name,
-		Raw:   string(b),
-		Links: map[string]bool{},
+		Name:      name,
+		Raw:       raw,
+		Links:     map[string]bool{},
+		linkCount: map[string]int{},
 	}
 
+	// Process frontmatter, everything else only sees the body
+	meta, body, err := splitFrontmatter(p.Raw)
+	if err != nil {
+		slog.Warn("invalid frontmatter", "page", name, "error", err)
+	}
+	p.Meta = meta
+	body = strings.TrimLeft(body, "\n")
+
 	// Process title (if '# ' get string until newline)
-	if strings.HasPrefix(p.Raw, "# ") && strings.Index(p.Raw, "\n") > 0 {
-		p.Title = strings.TrimSpace(p.Raw[2:strings.Index(p.Raw, "\n")])
+	if strings.HasPrefix(body, "# ") && strings.Index(body, "\n") > 0 {
+		p.Title = strings.TrimSpace(body[2:strings.Index(body, "\n")])
+	} else if title, ok := p.Meta["title"].(string); ok {
+		p.Title = title
+	}
+	p.Tags = metaStrings(p.Meta["tags"])
+	p.Draft = p.Meta["draft"] == true || strings.HasPrefix(path.Base(name), "_")
+	p.hidden = p.Draft && !w.Options.IncludeDrafts
+	p.Aliases = metaStrings(p.Meta["aliases"])
+
+	p.Excerpt = extractIntro(body)
+
+	// Process redirect (only looked for near the top of the page). Only
+	// page names are followed, never other sites.
+	head := body[:min(len(body), 500)]
+	if sub := redirectRe.FindStringSubmatch(head); sub != nil && isValidPagePath(sub[1]) {
+		p.RedirectTo = sub[1]
 	}
 
 	// Process wikilinks
-	processed := linkRe.ReplaceAllStringFunc(p.Raw, func(m string) string {
+	processed := linkRe.ReplaceAllStringFunc(body, func(m string) string {
 		sub := linkRe.FindStringSubmatch(m)
-		if len(sub) >= 2 {
-			target := strings.TrimSpace(sub[1])
-			p.Links[target] = true // Add link to page set
+		target, section := w.normalizeName(cleanTarget(sub[1])), strings.TrimSpace(sub[2])
+		if target == "" && section == "" {
+			return m // empty [[]]
+		}
+		if target != "" {
+			p.Links[target] = true // Add link to page set, [[#section]] stays on this page
+			p.linkCount[target]++
+		}
 
-			label := strings.TrimSpace(sub[2]) // empty if no |label
-			if label == "" {
-				label = target
+		label := strings.TrimSpace(sub[3]) // empty if no |label
+		if label == "" {
+			label = cleanTarget(sub[1]) + section
+		}
+		return fmt.Sprintf("[%s](%s%s)", label, target, section)
+	})
+
+	// Parse markdown and collect external links and headings
+	src := []byte(processed)
+	doc := w.md.Parser().Parse(text.NewReader(src))
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		var dest string
+		switch l := n.(type) {
+		case *ast.Link:
+			dest = string(l.Destination)
+		case *ast.AutoLink:
+			dest = string(l.URL(src))
+		case *ast.Paragraph:
+			if p.Description == "" && l.Parent() == doc {
+				p.Description = truncate(strings.TrimSpace(nodeText(l, src)), descriptionLength)
 			}
-			return fmt.Sprintf("[%s](%s)", label, target)
+		case *ast.Heading:
+			if id, ok := l.AttributeString("id"); ok && l.Level <= 4 {
+				p.TOC = append(p.TOC, TOCEntry{
+					Level: l.Level,
+					ID:    string(id.([]byte)),
+					Text:  nodeText(l, src),
+				})
+			}
+		}
+		if strings.HasPrefix(dest, "http://") || strings.HasPrefix(dest, "https://") {
+			p.ExternalLinks = append(p.ExternalLinks, dest)
 		}
-		return m // Match but not right size... empty [[]]?
+		return ast.WalkContinue, nil
 	})
 
+	p.WordCount = len(strings.Fields(nodeText(doc, src)))
+	p.ReadingTime = readingTime(p.WordCount)
+
+	if len(p.TOC) < w.Options.TOCMinHeadings {
+		p.TOC = nil
+	}
+
 	// Render HTML
 	var sb strings.Builder
-	if err := md.Convert([]byte(processed), &sb); err != nil {
+	if err := w.md.Renderer().Render(&sb, src, doc); err != nil {
 		return nil, err
 	}
 	p.HTML = template.HTML(sb.String())
+	if !w.Options.NoSanitize {
+		p.HTML = sanitizeHTML(p.HTML)
+	}
+	if !w.Options.NoLazyImages {
+		p.HTML = addLazyLoading(p.HTML)
+	}
+	p.rendered = p.HTML
 
 	return p, nil
 }
 
-// Create page data from a directory
-func loadPages(dir string) (map[string]*Page, error) {
+// Minutes to read words at 200 a minute, rounded up, or 0 if under a minute.
+func readingTime(words int) int {
+	const wordsPerMinute = 200
+	if words < wordsPerMinute {
+		return 0
+	}
+	return (words + wordsPerMinute - 1) / wordsPerMinute
+}
+
+// Maximum length of Page.Description in runes
+const descriptionLength = 200
+
+// s cut to at most n runes, ending in an ellipsis if anything was cut.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return strings.TrimSpace(string(r[:n-1])) + "…"
+}
+
+// The plain text inside a node, without any markup.
+func nodeText(n ast.Node, src []byte) string {
+	var sb strings.Builder
+	ast.Walk(n, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			switch t := n.(type) {
+			case *ast.Text:
+				sb.Write(t.Segment.Value(src))
+				if t.SoftLineBreak() {
+					sb.WriteByte(' ')
+				}
+			case *ast.String:
+				sb.Write(t.Value)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	return sb.String()
+}
+
+// Whether markdown files in dir, relative to the wiki root, are pages.
+// Hidden directories like .git are skipped, as is _static which is
+// served as it is.
+func isPageDir(dir string) bool {
+	for _, part := range strings.Split(dir, "/") {
+		if part != "." && strings.HasPrefix(part, ".") {
+			return false
+		}
+	}
+	return dir != "_static" && !strings.HasPrefix(dir, "_static/")
+}
+
+// Whether a file or directory with this base name matches Options.Ignore.
+func (w *Wiki) isIgnored(name string) bool {
+	for _, pattern := range w.Options.Ignore {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Create page data from the wiki directory. Unless force, pages whose
+// files have the same modification time as before are kept, and if no
+// page changed w.Pages is returned as it is. Callers hold the write lock.
+func (w *Wiki) loadPages(force bool) (pages map[string]*Page, changed bool, err error) {
 	var mdFiles []string
-	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+	err = fs.WalkDir(w.FS, ".", func(file string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if d.IsDir() {
+		if d.IsDir() && (!isPageDir(file) || file != "." && w.isIgnored(d.Name())) {
+			return fs.SkipDir
+		} else if d.IsDir() || w.isIgnored(d.Name()) {
 			return nil
 		}
+		// NOTE: this also skips {name}.md.bak backups
 		if strings.HasSuffix(d.Name(), ".md") {
-			mdFiles = append(mdFiles, path)
+			mdFiles = append(mdFiles, file)
 		}
 		return nil
 	})
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
+	// Sorted so that the reported errors don't depend on goroutine timing
+	slices.Sort(mdFiles)
+
 	// Load pages concurrently
 	pageCh := make(chan *Page)
-	errCh := make(chan error, 1)
+	errs := make([]error, len(mdFiles)) // indexed like mdFiles
 	var wg sync.WaitGroup
-	for _, path := range mdFiles {
+	for i, file := range mdFiles {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 
-			page, err := loadPage(path)
-			if err != nil {
-				select {
-				case errCh <- fmt.Errorf("error loading page %s: %w", path, err):
-				default:
+			// Skip reading files that look untouched
+			if old, ok := w.Pages[strings.TrimSuffix(file, ".md")]; ok && !force {
+				if info, err := fs.Stat(w.FS, file); err == nil && info.ModTime().Equal(old.ModTime) {
+					pageCh <- old
+					return
 				}
+			}
+			page, err := w.loadPage(file, force)
+			if err != nil {
+				errs[i] = fmt.Errorf("error loading page %s: %w", file, err)
 				return
 			}
 			pageCh <- page
@@ -233,93 +712,488 @@ func loadPages(dir string) (map[string]*Page, error) {
 	}()
 
 	// Process pages as they come in
-	pages := map[string]*Page{}
+	pages = map[string]*Page{}
 	for page := range pageCh {
 		pages[page.Name] = page
 	}
 
-	// Abort if any page errored. NOTE: could be better.
-	select {
-	case err := <-errCh:
-		return nil, err
-	default:
+	// Abort reporting every file that failed, in lexical order
+	if err := errors.Join(errs...); err != nil {
+		return nil, false, err
 	}
 
-	// Add /search page if it doesn't exist
-	if _, ok := pages["search"]; !ok {
-		pages["search"] = &Page{
-			Name: "search",
-			Raw:  "# Search",
+	// loadPage returns the old page if its content is the same
+	changed = force
+	for name, p := range pages {
+		changed = changed || w.Pages[name] != p
+	}
+	for name, p := range w.Pages {
+		if _, ok := pages[name]; !ok && !p.ModTime.IsZero() {
+			changed = true // deleted
 		}
 	}
+	if !changed {
+		return w.Pages, false, nil
+	}
+
+	addBuiltinPages(pages)
 
 	// Build backlinks
-	buildBacklinks(pages)
-	return pages, nil
+	w.buildBacklinks(pages)
+	return pages, true, nil
 }
 
-// Scan directory for .md files and build pages with backlinks.
-// NOTE: Later handle updating the template if it changes.
-// NOTE: Implement the updating of single files!
+// Add /search etc. if they don't exist.
+func addBuiltinPages(pages map[string]*Page) {
+	for name, title := range builtinPages {
+		if _, ok := pages[name]; !ok {
+			pages[name] = &Page{
+				Name:  name,
+				Title: title,
+				Raw:   "# " + title,
+			}
+		}
+	}
+}
+
+// Scan directory for .md files and build pages with backlinks, only
+// reading pages whose files have a new modification time.
+// The template and style are reloaded separately, see ReloadTemplate.
 func (w *Wiki) Update() error {
+	return w.update(false, false)
+}
+
+// Like Update but if a reload is already running, waits for it to finish
+// and then reloads rather than failing with ErrReloadInProgress. Files
+// written before calling it are always loaded.
+func (w *Wiki) UpdateWait() error {
+	return w.update(false, true)
+}
+
+// Like Update but reading and parsing every page file again, whatever
+// its modification time or content.
+func (w *Wiki) ForceUpdate() error {
+	return w.update(true, false)
+}
+
+func (w *Wiki) update(force, wait bool) error {
+	if wait {
+		w.reloadSem <- struct{}{}
+		defer func() { <-w.reloadSem }()
+	} else {
+		select {
+		case w.reloadSem <- struct{}{}:
+			defer func() { <-w.reloadSem }()
+		default:
+			return ErrReloadInProgress
+		}
+	}
+
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	pages, err := loadPages(w.Dir)
+	start := time.Now()
+	pages, changed, err := w.loadPages(force)
 	if err != nil {
 		return err
 	}
-	w.Pages = pages
+	if changed {
+		w.Pages = pages
+		w.index = buildSearchIndex(pages)
+		w.Tags = buildTags(pages)
+	}
+
+	elapsed := time.Since(start)
+	slog.Debug("wiki reloaded", "pages", len(w.Pages), "changed", changed, "duration", elapsed)
+	w.Metrics.ReloadCount.Add(1)
+	if w.observeReload != nil {
+		w.observeReload(elapsed)
+	}
 	return nil
 }
 
+// Render markdown as page name would show it, without saving it or
+// changing the wiki. Embeds are expanded from the current pages,
+// private ones only for an authorized reader previewing a private page.
+func (w *Wiki) Preview(name, raw string, authorized bool) (template.HTML, error) {
+	p, err := w.parsePage(name, strings.TrimPrefix(raw, utf8BOM))
+	if err != nil {
+		return "", err
+	}
+	w.mu.RLock()
+	html := w.expandEmbeds(w.Pages, p, authorized && !p.IsPublic())
+	w.mu.RUnlock()
+	if prefix := rootPrefix(name); prefix != "" {
+		html = rebaseLinks(html, prefix)
+	}
+	return html, nil
+}
+
+// Whether the wiki has a page called name.
+func (w *Wiki) hasPage(name string) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	_, ok := w.Pages[name]
+	return ok
+}
+
+// A copy of a page that is safe to use after the lock is released, e.g.
+// while executing a template during a concurrent reload.
+func (w *Wiki) ReadPage(name string) (Page, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	p, ok := w.Pages[name]
+	if !ok {
+		return Page{}, false
+	}
+	page := *p
+	page.Links = maps.Clone(p.Links)
+	page.Backlinks = slices.Clone(p.Backlinks)
+	page.BacklinkCounts = maps.Clone(p.BacklinkCounts)
+	page.ExternalLinks = slices.Clone(p.ExternalLinks)
+	page.Tags = slices.Clone(p.Tags)
+	page.Aliases = slices.Clone(p.Aliases)
+	return page, true
+}
+
+// Names of pages equal to name ignoring case, sorted.
+func (w *Wiki) namesEqualFold(name string) []string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	var names []string
+	for n := range w.Pages {
+		if strings.EqualFold(n, name) {
+			names = append(names, n)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Up to limit pages, most recently modified first. Built-in pages
+// without a file and drafts are left out, as are private pages unless
+// authorized.
+func (w *Wiki) RecentChanges(limit int, authorized bool) []*Page {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	var pages []*Page
+	for _, p := range w.Pages {
+		if !p.ModTime.IsZero() && !p.hidden && w.visible(p, authorized) {
+			page := *p
+			pages = append(pages, &page)
+		}
+	}
+	slices.SortFunc(pages, func(a, b *Page) int {
+		if c := b.ModTime.Compare(a.ModTime); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	if len(pages) > limit {
+		pages = pages[:limit]
+	}
+	return pages
+}
+
 // Just update the parsed properties of a single page (no backlinks change).
 func (w *Wiki) UpdateSingle(name string) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	page, err := loadPage(w.getPagePath(name))
+	start := time.Now()
+	page, err := w.loadPage(getPageFile(name), false)
 	if err != nil {
 		return err
 	}
 	w.Pages[name] = page
+	w.index.remove(name)
+	w.index.add(page)
+	w.Tags = buildTags(w.Pages)
 
-	buildBacklinks(w.Pages)
+	w.buildBacklinks(w.Pages)
+	slog.Debug("page reloaded", "name", name, "duration", time.Since(start))
 	return nil
 }
 
+// Write a page's markdown, first keeping the previous version as {name}.md.bak
+// unless backups are disabled.
 func (w *Wiki) WritePage(name string, content string) error {
-	return os.WriteFile(w.getPagePath(name), []byte(content), 0644)
+	path := w.getPagePath(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if !w.Options.NoBackup {
+		if err := backupFile(path); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(path, []byte(content)); err != nil {
+		return err
+	}
+	if w.Options.Git {
+		// The edit is saved either way, so only warn
+		if err := w.commitPage(name, "Edit "+name); err != nil {
+			slog.Warn("failed to commit page", "page", name, "error", err)
+		}
+	}
+	return nil
+}
+
+// Write data to path via a synced temp file in the same directory, so
+// a crash leaves either the old content or the new, never a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // fails harmlessly once renamed
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(0644) // like os.WriteFile, CreateTemp uses 0600
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
 }
 
-func (w *Wiki) RenamePage(oldName string, newName string) error {
+// Copy path to path.bak if it exists. Only one version is kept.
+func backupFile(path string) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return os.WriteFile(path+".bak", b, 0644)
+}
+
+// Write several pages at once. Content is first written to temp files which
+// are then renamed into place; if anything fails, earlier renames are undone.
+// Like WritePage, existing pages are backed up and with Options.Git the
+// pages are committed, here together.
+// Returns the number of pages that did not previously exist.
+func (w *Wiki) WritePages(contents map[string]string) (int, error) {
+	type pending struct {
+		name, tmp, path string
+		orig            []byte // nil if the page is new
+	}
+	var writes []pending
+	cleanup := func() {
+		for _, p := range writes {
+			os.Remove(p.tmp)
+		}
+	}
+
+	for name, content := range contents {
+		// Temp files go beside the page so renaming them is atomic
+		dest := w.getPagePath(name)
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			cleanup()
+			return 0, err
+		}
+		f, err := os.CreateTemp(filepath.Dir(dest), "."+path.Base(name)+"-*.tmp")
+		if err != nil {
+			cleanup()
+			return 0, err
+		}
+		p := pending{name: name, tmp: f.Name(), path: dest}
+		writes = append(writes, p)
+		_, err = f.WriteString(content)
+		if err == nil {
+			err = f.Chmod(0644) // like os.WriteFile, CreateTemp uses 0600
+		}
+		if err == nil {
+			err = f.Sync()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			cleanup()
+			return 0, err
+		}
+	}
+
+	// Remember the originals so a failed rename can be rolled back.
+	created := 0
+	for i := range writes {
+		b, err := os.ReadFile(writes[i].path)
+		if errors.Is(err, fs.ErrNotExist) {
+			created++
+		} else if err != nil {
+			cleanup()
+			return 0, err
+		}
+		writes[i].orig = b
+		if !w.Options.NoBackup {
+			if err := backupFile(writes[i].path); err != nil {
+				cleanup()
+				return 0, err
+			}
+		}
+	}
+
+	for i, p := range writes {
+		if err := os.Rename(p.tmp, p.path); err != nil {
+			for _, done := range writes[:i] {
+				if done.orig == nil {
+					os.Remove(done.path)
+				} else {
+					os.WriteFile(done.path, done.orig, 0644)
+				}
+			}
+			cleanup()
+			return 0, err
+		}
+	}
+
+	if w.Options.Git {
+		files := make([]string, len(writes))
+		for i, p := range writes {
+			files[i] = getPageFile(p.name)
+		}
+		// The pages are saved either way, so only warn
+		if err := w.commitFiles(fmt.Sprintf("Edit %d pages", len(files)), files...); err != nil {
+			slog.Warn("failed to commit pages", "pages", len(files), "error", err)
+		}
+	}
+	return created, nil
+}
+
+// Delete a page's file. Pages linking to it keep their links, which
+// are then marked as broken.
+func (w *Wiki) DeletePage(name string) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	err := os.Rename(w.getPagePath(oldName), w.getPagePath(newName))
-	if err != nil {
+	if err := os.Remove(w.getPagePath(name)); err != nil {
 		return err
 	}
-	w.Pages[newName] = w.Pages[oldName]
+	if w.Options.Git {
+		// The file is gone either way, so only warn
+		if err := w.commitFiles("Delete "+name, getPageFile(name)); err != nil {
+			slog.Warn("failed to commit deletion", "page", name, "error", err)
+		}
+	}
+	delete(w.Pages, name)
+	w.index.remove(name)
+	w.Tags = buildTags(w.Pages)
+	// Deleting search.md leaves the built-in search page
+	addBuiltinPages(w.Pages)
+
+	w.buildBacklinks(w.Pages)
+	return nil
+}
+
+// Returned by Update when another full reload is already running.
+var ErrReloadInProgress = errors.New("reload already in progress")
+
+// Returned when a rename would replace an existing page.
+var ErrPageExists = errors.New("page already exists")
+
+// Rename a page and rewrite the wikilinks of every page linking to it.
+// Unless overwrite is set, renaming onto an existing page fails with ErrPageExists.
+// Renaming a page to its own name does nothing.
+func (w *Wiki) RenamePage(oldName string, newName string, overwrite bool) error {
+	if oldName == newName {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Aliases and redirects may be replaced, e.g. when renaming back
+	if p, ok := w.Pages[newName]; ok && p.Alias == "" && p.RedirectTo == "" && !overwrite {
+		return ErrPageExists
+	}
+
+	newPath := w.getPagePath(newName)
+	if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(w.getPagePath(oldName), newPath); err != nil {
+		return err
+	}
+	if w.Options.Git {
+		// Committed before the stub replaces the old file, so history
+		// follows the page to its new name. The rename is done either way,
+		// so only warn.
+		msg := fmt.Sprintf("Rename %s to %s", oldName, newName)
+		if err := w.commitFiles(msg, getPageFile(oldName), getPageFile(newName)); err != nil {
+			slog.Warn("failed to commit rename", "from", oldName, "to", newName, "error", err)
+		}
+	}
+	// Backlinks leave out drafts, whose links need rewriting too
+	var linkers []string
+	for name, p := range w.Pages {
+		if p.Links[oldName] {
+			linkers = append(linkers, name)
+		}
+	}
 	delete(w.Pages, oldName)
+	// Loaded again rather than moved so Name and anything derived from it
+	// match the new name.
+	renamed, err := w.loadPage(getPageFile(newName), false)
+	if err != nil {
+		return err
+	}
+	w.Pages[newName] = renamed
+
+	// Leave a stub so links from outside the wiki still work
+	stub := fmt.Sprintf("<!-- candl: redirect: %s -->\n", newName)
+	if err := w.WritePage(oldName, stub); err != nil {
+		return err
+	}
+	stubPage, err := w.loadPage(getPageFile(oldName), false)
+	if err != nil {
+		return err
+	}
+	w.Pages[oldName] = stubPage
 
 	// Now we need to write update all the backlinks to use the new name.
-	for _, linkingPageName := range w.Pages[newName].Backlinks {
+	for _, linkingPageName := range linkers {
+		if linkingPageName == oldName {
+			linkingPageName = newName // the page links to itself
+		}
 		linkingPage := w.Pages[linkingPageName]
 		// Edit the contents of the page file.
+		path := w.getPagePath(linkingPageName)
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
 		newContent := string(renameWikilinks([]byte(linkingPage.Raw), oldName, newName))
 		err = w.WritePage(linkingPageName, newContent)
 		if err != nil {
 			return err
 		}
+		// Only the link changed so keep the original mtime (zero atime is left as is).
+		if !w.Options.NoPreserveMtime {
+			if err := os.Chtimes(path, time.Time{}, info.ModTime()); err != nil {
+				return err
+			}
+		}
 		// Update the page object to reflect newly written file.
-		page, err := loadPage(w.getPagePath(linkingPageName))
+		page, err := w.loadPage(getPageFile(linkingPageName), false)
 		if err != nil {
 			return err
 		}
 		w.Pages[linkingPageName] = page
 	}
 
-	buildBacklinks(w.Pages)
+	w.index = buildSearchIndex(w.Pages)
+	w.Tags = buildTags(w.Pages)
+	w.buildBacklinks(w.Pages)
 	return nil
 }
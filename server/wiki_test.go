@@ -0,0 +1,400 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"math/rand/v2"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeletePageKeepsBuiltinPage(t *testing.T) {
+	wiki := newTestWiki(t, map[string]string{
+		"search": "# Find things\n",
+		"foo":    "# Foo\n[[search]]\n",
+	})
+
+	if err := wiki.DeletePage("search"); err != nil {
+		t.Fatal(err)
+	}
+	p, ok := wiki.ReadPage("search")
+	if !ok {
+		t.Fatal("built-in search page is gone")
+	}
+	if p.Title != "Search" {
+		t.Errorf("got title %q, want the built-in %q", p.Title, "Search")
+	}
+}
+
+func TestEditLockReissuedToHolder(t *testing.T) {
+	wiki := newTestWiki(t, map[string]string{"foo": "# Foo\n"})
+	wiki.Options.EditLockTimeout = time.Minute
+
+	first, ok := wiki.AcquireEditLock("foo", "alice")
+	if !ok {
+		t.Fatal("first lock refused")
+	}
+	if again, ok := wiki.AcquireEditLock("foo", "alice"); !ok || again != first {
+		t.Errorf("holder reopening: got %q, %v, want %q, true", again, ok, first)
+	}
+	if _, ok := wiki.AcquireEditLock("foo", "bob"); ok {
+		t.Error("another holder took the lock")
+	}
+
+	wiki.EditLocks["bar"] = EditLock{Token: "old", Holder: "bob", Expires: time.Now().Add(-time.Second)}
+	wiki.AcquireEditLock("foo", "alice")
+	if _, ok := wiki.EditLocks["bar"]; ok {
+		t.Error("expired lock wasn't pruned")
+	}
+}
+
+// Every file under dir with its content, keyed by slash separated path.
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		rel, _ := filepath.Rel(dir, path)
+		files[filepath.ToSlash(rel)] = string(b)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestRenameOntoExistingPage(t *testing.T) {
+	wiki := newTestWiki(t, map[string]string{
+		"old": "# Old\n",
+		"new": "# New\n",
+	})
+	before := readTree(t, wiki.Dir)
+
+	if err := wiki.RenamePage("old", "new", false); !errors.Is(err, ErrPageExists) {
+		t.Fatalf("got error %v, want %v", err, ErrPageExists)
+	}
+	if after := readTree(t, wiki.Dir); !maps.Equal(before, after) {
+		t.Errorf("files changed:\nbefore %q\nafter  %q", before, after)
+	}
+
+	if err := wiki.RenamePage("old", "new", true); err != nil {
+		t.Fatalf("overwriting: %v", err)
+	}
+	if after := readTree(t, wiki.Dir); after["new.md"] != "# Old\n" {
+		t.Errorf("overwriting: new.md is %q, want the old page", after["new.md"])
+	}
+}
+
+func TestLoadPageStripsBOM(t *testing.T) {
+	wiki := newTestWiki(t, map[string]string{"bom": utf8BOM + "# Notepad\nsaved with a BOM\n"})
+
+	p, ok := wiki.ReadPage("bom")
+	if !ok {
+		t.Fatal("page not loaded")
+	}
+	if p.Title != "Notepad" {
+		t.Errorf("got title %q, want %q", p.Title, "Notepad")
+	}
+	if strings.HasPrefix(p.Raw, utf8BOM) {
+		t.Errorf("raw content kept the BOM: %q", p.Raw)
+	}
+}
+
+// Content the child process in TestWriteFileAtomicSurvivesKill writes
+var killedWrite = bytes.Repeat([]byte("new content\n"), 4<<20)
+
+func TestWriteFileAtomicSurvivesKill(t *testing.T) {
+	if path := os.Getenv("CANDL_TEST_ATOMIC_WRITE"); path != "" {
+		// The child: keep a write in progress until killed
+		for {
+			writeFileAtomic(path, killedWrite)
+		}
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "page.md")
+	if err := os.WriteFile(path, []byte("old content\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, os.Args[0], "-test.run=^TestWriteFileAtomicSurvivesKill$")
+	cmd.Env = append(os.Environ(), "CANDL_TEST_ATOMIC_WRITE="+path)
+	if err := cmd.Run(); ctx.Err() == nil {
+		t.Fatalf("writer wasn't killed: %v", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "old content\n" && !bytes.Equal(b, killedWrite) {
+		t.Errorf("page is a partial write of %d bytes", len(b))
+	}
+	if tmps, _ := filepath.Glob(filepath.Join(dir, ".page.md-*.tmp")); len(tmps) == 0 {
+		t.Log("writer was killed between writes, not during one")
+	}
+}
+
+func TestRenamePageRewritesLinks(t *testing.T) {
+	tests := []struct {
+		name     string
+		pages    map[string]string
+		old, new string
+		want     map[string]string // files expected afterwards
+	}{
+		{
+			name:  "same name",
+			pages: map[string]string{"a": "[[a]]\n"},
+			old:   "a", new: "a",
+			want: map[string]string{"a.md": "[[a]]\n"},
+		},
+		{
+			name:  "self link",
+			pages: map[string]string{"a": "# A\n[[a]]\n"},
+			old:   "a", new: "b",
+			want: map[string]string{"b.md": "# A\n[[b]]\n"},
+		},
+		{
+			name:  "cycle",
+			pages: map[string]string{"a": "[[b]]\n", "b": "[[a|back]]\n"},
+			old:   "a", new: "c",
+			want: map[string]string{"b.md": "[[c|back]]\n", "c.md": "[[b]]\n"},
+		},
+		{
+			name:  "draft linker",
+			pages: map[string]string{"a": "# A\n", "_notes": "[[a]]\n"},
+			old:   "a", new: "b",
+			want: map[string]string{"_notes.md": "[[b]]\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wiki := newTestWiki(t, tt.pages)
+			wiki.Options.NoBackup = true
+
+			if err := wiki.RenamePage(tt.old, tt.new, false); err != nil {
+				t.Fatal(err)
+			}
+			files := readTree(t, wiki.Dir)
+			for file, want := range tt.want {
+				if files[file] != want {
+					t.Errorf("%s: got %q, want %q", file, files[file], want)
+				}
+			}
+
+			p, ok := wiki.ReadPage(tt.new)
+			if !ok || p.Name != tt.new {
+				t.Fatalf("page %s: got %q, %v", tt.new, p.Name, ok)
+			}
+			if tt.old == tt.new {
+				return
+			}
+			if old, _ := wiki.ReadPage(tt.old); old.RedirectTo != tt.new {
+				t.Errorf("%s redirects to %q, want %q", tt.old, old.RedirectTo, tt.new)
+			}
+			if slices.Contains(p.Backlinks, tt.old) {
+				t.Errorf("%s is still linked from %s", tt.new, tt.old)
+			}
+		})
+	}
+}
+
+func TestWikilinkForms(t *testing.T) {
+	tests := []struct {
+		link    string
+		html    string // the rendered link
+		renamed string // the link once dest is renamed to moved
+	}{
+		{"[[dest]]", `<a href="dest">dest</a>`, "[[moved]]"},
+		{"[[dest#setup]]", `<a href="dest#setup">dest#setup</a>`, "[[moved#setup]]"},
+		{"[[dest|the docs]]", `<a href="dest">the docs</a>`, "[[moved|the docs]]"},
+		{"[[dest#setup|set up]]", `<a href="dest#setup">set up</a>`, "[[moved#setup|set up]]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.link, func(t *testing.T) {
+			wiki := newTestWiki(t, map[string]string{
+				"src":  "see " + tt.link + "\n",
+				"dest": "# Dest\n## Setup\n",
+			})
+			p, _ := wiki.ReadPage("src")
+			if !strings.Contains(string(p.HTML), tt.html) {
+				t.Errorf("got HTML %q, want it to contain %q", p.HTML, tt.html)
+			}
+			if links := slices.Sorted(maps.Keys(p.Links)); !slices.Equal(links, []string{"dest"}) {
+				t.Errorf("got links %q, want [dest]", links)
+			}
+			if got := string(renameWikilinks([]byte(tt.link), "dest", "moved")); got != tt.renamed {
+				t.Errorf("renamed to %q, want %q", got, tt.renamed)
+			}
+		})
+	}
+}
+
+func TestHeadingIDs(t *testing.T) {
+	wiki := newTestWiki(t, map[string]string{
+		"guide": "# Getting Started\n## Install the Tool\n## Usage\n",
+		"index": "[[guide#install-the-tool]]\n",
+	})
+	guide, _ := wiki.ReadPage("guide")
+	for _, id := range []string{"getting-started", "install-the-tool", "usage"} {
+		if !strings.Contains(string(guide.HTML), `id="`+id+`"`) {
+			t.Errorf("no heading with id %q in %q", id, guide.HTML)
+		}
+	}
+
+	// The ids are what section links point at
+	index, _ := wiki.ReadPage("index")
+	if !strings.Contains(string(index.HTML), `href="guide#install-the-tool"`) {
+		t.Errorf("section link not rendered: %q", index.HTML)
+	}
+}
+
+func TestSortBacklinks(t *testing.T) {
+	tests := []struct {
+		in, want []string
+	}{
+		{[]string{"10", "9", "abc", "1def"}, []string{"abc", "10", "9", "1def"}},
+		{[]string{"b", "a", "C"}, []string{"C", "a", "b"}},
+		{[]string{"2024-01-05", "2023-12-31", "2024-02-01"}, []string{"2024-02-01", "2024-01-05", "2023-12-31"}},
+		{[]string{"100", "99", "notes", "2"}, []string{"notes", "100", "99", "2"}},
+	}
+	for _, tt := range tests {
+		got := slices.Clone(tt.in)
+		slices.SortFunc(got, sortBacklinks)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("sorting %q: got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateReportsEveryBadPage(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "good.md"), []byte("# Good\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Dangling links can't be read, even by root
+	bad := []string{"one.md", "two.md", "three.md"}
+	for _, name := range bad {
+		if err := os.Symlink(filepath.Join(dir, "missing-"+name), filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wiki, err := NewWiki(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = wiki.ForceUpdate()
+	if err == nil {
+		t.Fatal("no error loading unreadable pages")
+	}
+	for _, name := range bad {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error doesn't mention %s: %v", name, err)
+		}
+	}
+}
+
+// Compare the SortAlpha and default backlink orders on 1000 backlinks.
+func BenchmarkSortBacklinks(b *testing.B) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	names := make([]string, 1000)
+	for i := range names {
+		if i%4 == 0 {
+			names[i] = fmt.Sprintf("%d-%02d-%02d", 2000+rng.IntN(25), 1+rng.IntN(12), 1+rng.IntN(28))
+		} else {
+			names[i] = fmt.Sprintf("page-%d", rng.IntN(100000))
+		}
+	}
+
+	sorts := []struct {
+		name string
+		sort func([]string)
+	}{
+		{"Sort", slices.Sort[[]string]},
+		{"SortFunc", func(s []string) { slices.SortFunc(s, sortBacklinks) }},
+	}
+	for _, s := range sorts {
+		b.Run(s.name, func(b *testing.B) {
+			backlinks := make([]string, len(names))
+			for b.Loop() {
+				copy(backlinks, names)
+				s.sort(backlinks)
+			}
+		})
+	}
+}
+
+// Compare reloading a 1000 page wiki after one page changes with reading
+// every page again.
+func BenchmarkReload(b *testing.B) {
+	pages := map[string]string{}
+	for i := range 1000 {
+		pages[fmt.Sprintf("p%d", i)] = fmt.Sprintf("# Page %d\n[[p%d]] [[p%d]]\n", i, i+1, 7*i)
+	}
+	wiki := newTestWiki(b, pages)
+	path := filepath.Join(wiki.Dir, "p500.md")
+
+	b.Run("Update", func(b *testing.B) {
+		mtime := time.Now()
+		for i := 0; b.Loop(); i++ {
+			raw := fmt.Sprintf("# Page 500, edit %d\n[[p501]] [[p%d]]\n", i, i%1000)
+			if err := os.WriteFile(path, []byte(raw), 0644); err != nil {
+				b.Fatal(err)
+			}
+			// Writes within the clock's resolution could look untouched
+			mtime = mtime.Add(time.Second)
+			if err := os.Chtimes(path, time.Time{}, mtime); err != nil {
+				b.Fatal(err)
+			}
+			if err := wiki.Update(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("ForceUpdate", func(b *testing.B) {
+		for b.Loop() {
+			if err := wiki.ForceUpdate(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestBacklinkCountsThroughAliases(t *testing.T) {
+	wiki := newTestWiki(t, map[string]string{
+		"canonical": "---\naliases: [alias]\n---\n# Canonical\n",
+		"linker":    "[[alias]] [[alias|again]] [[canonical]]\n",
+	})
+	p, _ := wiki.ReadPage("canonical")
+	if got := p.BacklinkCounts["linker"]; got != 3 {
+		t.Errorf("got %d links from linker, want 3", got)
+	}
+}
+
+func TestForceUpdateParsesAgain(t *testing.T) {
+	wiki := newTestWiki(t, map[string]string{"a": "# A\n"})
+	before := wiki.Pages["a"]
+
+	if err := wiki.ForceUpdate(); err != nil {
+		t.Fatal(err)
+	}
+	if wiki.Pages["a"] == before {
+		t.Error("ForceUpdate reused the loaded page")
+	}
+}